@@ -0,0 +1,306 @@
+package tasker
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/volatiletech/null"
+	"go.opentelemetry.io/otel/trace"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+var taskStatuses = []string{
+	m.TaskStatusTodo,
+	m.TaskStatusDoing,
+	m.TaskStatusDone,
+	m.TaskStatusError,
+	m.TaskStatusCancelled,
+}
+
+// TaskFilter restricts the tasks returned by ListTasks, zero values are ignored
+type TaskFilter struct {
+	Name   string
+	Status string
+	After  time.Time
+	Before time.Time
+	Limit  int
+	Offset int
+}
+
+// EnqueueParams describes a task to insert in the queue
+type EnqueueParams struct {
+	Name     string
+	Args     interface{}
+	TodoDate time.Time
+	// DedupKey is optional, a task is not enqueued when a task with the same
+	// name and dedup key exists
+	DedupKey string
+	// Version is the version of the task definition, Scheduler.Enqueue sets
+	// the latest registered one when 0. The other enqueue paths don't know
+	// the definitions, the task gets the latest version when first claimed.
+	Version int
+
+	// queue is the queue of the first step, set by Scheduler.Enqueue
+	queue string
+}
+
+// NameStatusCount is the number of tasks with a given name and status
+type NameStatusCount struct {
+	Name   string `boil:"name" json:"name"`
+	Status string `boil:"status" json:"status"`
+	Count  int64  `boil:"count" json:"count"`
+	// OldestDue is the smallest todo date of these tasks
+	OldestDue time.Time `boil:"oldest_due" json:"oldest_due"`
+}
+
+// StepCount is the number of pending tasks of a task version at a step
+type StepCount struct {
+	Name    string `boil:"name" json:"name"`
+	Version int    `boil:"version" json:"version"`
+	Step    string `boil:"actual_step" json:"step"`
+	Count   int64  `boil:"count" json:"count"`
+}
+
+// QueueStats is a summary of the queue content
+type QueueStats struct {
+	ByStatus   map[string]int64  `json:"by_status"`
+	ByName     []NameStatusCount `json:"by_name"`
+	OldestTodo *time.Time        `json:"oldest_todo,omitempty"`
+}
+
+// ThroughputBucket is the number of step executions finished in an hour
+type ThroughputBucket struct {
+	Hour      time.Time `boil:"hour" json:"hour"`
+	Succeeded int64     `boil:"succeeded" json:"succeeded"`
+	Failed    int64     `boil:"failed" json:"failed"`
+}
+
+// ListTasks returns the tasks matching the filter, most recent first
+func ListTasks(ctx context.Context, f TaskFilter) (m.TaskSlice, error) {
+	return defaultStore().List(ctx, f)
+}
+
+// GetTask returns the task with the given id
+func GetTask(ctx context.Context, id string) (*m.Task, error) {
+	return defaultStore().Get(ctx, id)
+}
+
+// Enqueue inserts a new task in the queue, when a task with the same name and
+// dedup key exists it is returned instead
+func Enqueue(ctx context.Context, p EnqueueParams) (*m.Task, error) {
+	tasks, _, err := enqueue(ctx, defaultStore(), []EnqueueParams{p})
+	if err != nil {
+		return nil, err
+	}
+	return tasks[0], nil
+}
+
+// EnqueueMany inserts many tasks at once, which is much faster than calling
+// Enqueue for each of them. It returns the ID of each task, the ID of the
+// existing task for the duplicates.
+func EnqueueMany(ctx context.Context, params []EnqueueParams) ([]string, error) {
+	tasks, _, err := enqueue(ctx, defaultStore(), params)
+	if err != nil {
+		return nil, err
+	}
+	return taskIDs(tasks), nil
+}
+
+func taskIDs(tasks m.TaskSlice) []string {
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	return ids
+}
+
+// enqueue inserts the tasks described by params, it returns all the tasks
+// and the ones which were actually inserted
+func enqueue(ctx context.Context, store Store, params []EnqueueParams) (tasks, inserted m.TaskSlice, err error) {
+	if len(params) == 0 {
+		return nil, nil, nil
+	}
+
+	spanName := "enqueue " + params[0].Name
+	if len(params) > 1 {
+		spanName = "enqueue many"
+	}
+	ctx, span := tracer().Start(ctx, spanName,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(AttrEnqueueCount.Int(len(params))))
+	defer func() {
+		if len(params) == 1 && len(tasks) == 1 {
+			span.SetAttributes(AttrTaskName.String(tasks[0].Name), AttrTaskID.String(tasks[0].ID))
+		}
+		endSpan(span, err)
+	}()
+
+	tasks = make(m.TaskSlice, len(params))
+	ids := make([]string, len(params))
+	for i, p := range params {
+		tasks[i], err = newTask(ctx, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[i] = tasks[i].ID
+	}
+
+	err = store.Enqueue(ctx, tasks...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The duplicates were replaced by the existing tasks
+	for i, task := range tasks {
+		if task.ID == ids[i] {
+			metrics.TaskEnqueued(task.Name)
+			inserted = append(inserted, task)
+		}
+	}
+	return tasks, inserted, nil
+}
+
+// newTask returns the task described by p with a new ID
+func newTask(ctx context.Context, p EnqueueParams) (*m.Task, error) {
+	if p.Name == "" {
+		return nil, ErrMissingTaskName
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	task := &m.Task{
+		ID:       id.String(),
+		Name:     p.Name,
+		Status:   m.TaskStatusTodo,
+		TodoDate: p.TodoDate,
+		Version:  p.Version,
+		Queue:    p.queue,
+	}
+	if task.TodoDate.IsZero() {
+		task.TodoDate = time.Now()
+	}
+	if p.DedupKey != "" {
+		task.DedupKey = null.StringFrom(p.DedupKey)
+	}
+
+	if p.Args != nil {
+		err = task.UserArgs.Marshal(p.Args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = injectTraceContext(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// RetryTask puts a failed or cancelled task back in the queue, it returns
+// ErrInvalidStatus for a task in another status
+func RetryTask(ctx context.Context, id string) (*m.Task, error) {
+	task, err := GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	task.Status = m.TaskStatusTodo
+	task.Retry = 0
+	task.TodoDate = time.Now()
+	task.FinishedAt = null.Time{}
+	err = defaultStore().UpdateIf(ctx, []string{
+		m.TaskColumns.Status, m.TaskColumns.Retry, m.TaskColumns.TodoDate, m.TaskColumns.FinishedAt,
+	}, task, m.TaskStatusError, m.TaskStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// ForceMoveTask puts a pending or failed task back in the queue at the given
+// step without checking it, for the tools which don't know the task
+// definitions like the CLI. Scheduler.MoveTask checks the step. The task goes
+// to the default queue, a scheduler serving it routes the task to the queue
+// of the step.
+func ForceMoveTask(ctx context.Context, id, step string) (*m.Task, error) {
+	if step == "" {
+		return nil, ErrMissingStepName
+	}
+	task, err := GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return moveTask(ctx, defaultStore(), task, Step{Name: step})
+}
+
+func moveTask(ctx context.Context, store Store, task *m.Task, step Step) (*m.Task, error) {
+	task.ActualStep = step.Name
+	task.Queue = step.Queue
+	task.Status = m.TaskStatusTodo
+	task.Retry = 0
+	task.TodoDate = time.Now()
+	task.Progress = null.JSON{}
+	task.FinishedAt = null.Time{}
+	err := store.UpdateIf(ctx, []string{
+		m.TaskColumns.ActualStep, m.TaskColumns.Status, m.TaskColumns.Retry,
+		m.TaskColumns.TodoDate, m.TaskColumns.Progress, m.TaskColumns.Version,
+		m.TaskColumns.FinishedAt, m.TaskColumns.Queue,
+	}, task, m.TaskStatusTodo, m.TaskStatusError, m.TaskStatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// CancelTask prevents a pending task from being executed, it returns
+// ErrInvalidStatus for a task already claimed
+func CancelTask(ctx context.Context, id string) (*m.Task, error) {
+	task, err := GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	task.Status = m.TaskStatusCancelled
+	task.FinishedAt = null.TimeFrom(time.Now())
+	err = defaultStore().UpdateIf(ctx, []string{m.TaskColumns.Status, m.TaskColumns.FinishedAt}, task,
+		m.TaskStatusTodo, m.TaskStatusError)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Stats returns the number of tasks per status and name
+func Stats(ctx context.Context) (*QueueStats, error) {
+	stats := &QueueStats{
+		ByStatus: map[string]int64{},
+	}
+
+	counts, err := defaultStore().Counts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.ByName = counts
+
+	for _, c := range counts {
+		stats.ByStatus[c.Status] += c.Count
+		if c.Status == m.TaskStatusTodo && (stats.OldestTodo == nil || c.OldestDue.Before(*stats.OldestTodo)) {
+			oldest := c.OldestDue
+			stats.OldestTodo = &oldest
+		}
+	}
+
+	return stats, nil
+}
+
+// Throughput returns the number of step executions per hour since the given
+// date, hours without any execution are omitted
+func Throughput(ctx context.Context, since time.Time) ([]*ThroughputBucket, error) {
+	return defaultStore().Throughput(ctx, since)
+}
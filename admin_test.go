@@ -0,0 +1,113 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestAdminLifecycle(t *testing.T) {
+	useMemoryStore(t)
+
+	task, err := Enqueue(ctx, EnqueueParams{
+		Name: "test",
+		Args: map[string]string{"user_address": "salut"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RetryTask(ctx, task.ID)
+	if err != ErrInvalidStatus {
+		t.Errorf("Should not retry a pending task")
+	}
+
+	task, err = CancelTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusCancelled {
+		t.Errorf("Task should be cancelled")
+	}
+
+	task, err = RetryTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusTodo {
+		t.Errorf("Task should be back to todo")
+	}
+
+	tasks, err := ListTasks(ctx, TaskFilter{Name: "test", Status: m.TaskStatusTodo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("Expected 1 task, got %d", len(tasks))
+	}
+
+	stats, err := Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ByStatus[m.TaskStatusTodo] != 1 || stats.OldestTodo == nil {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// The task is claimed while the operator looks at it
+	_, err = defaultStore().Claim(ctx, []string{"test"}, []string{""}, time.Now().Add(time.Minute), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CancelTask(ctx, task.ID)
+	if err != ErrInvalidStatus {
+		t.Errorf("Should not cancel a claimed task, got %v", err)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDoing {
+		t.Errorf("Expected the claimed task to keep running, got %s", task.Status)
+	}
+
+	_, err = GetTask(ctx, "c9f51923-293a-4e3b-a49f-cccd71db4679")
+	if err != ErrTaskNotFound {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestEnqueueMany(t *testing.T) {
+	useMemoryStore(t)
+
+	enqueued := 0
+	s := &Scheduler{Hooks: Hooks{OnEnqueue: func(task *m.Task) { enqueued++ }}}
+	ids, err := s.EnqueueMany(ctx, []EnqueueParams{
+		{Name: "test", DedupKey: "salut"},
+		{Name: "test", DedupKey: "salut"},
+		{Name: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != ids[1] || ids[0] == ids[2] {
+		t.Errorf("Unexpected ids %v", ids)
+	}
+	if enqueued != 2 {
+		t.Errorf("Expected 2 OnEnqueue calls, got %d", enqueued)
+	}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "test", DedupKey: "salut"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID != ids[0] || enqueued != 2 {
+		t.Errorf("Expected the existing task without OnEnqueue call")
+	}
+
+	_, err = EnqueueMany(ctx, []EnqueueParams{{Name: "test"}, {}})
+	if err != ErrMissingTaskName {
+		t.Errorf("Expected ErrMissingTaskName, got %v", err)
+	}
+}
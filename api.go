@@ -0,0 +1,273 @@
+package tasker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 1000
+)
+
+// Middleware wraps an http.Handler, typically to add authentication
+type Middleware func(http.Handler) http.Handler
+
+// TaskDetail is a task along with its attempt history
+type TaskDetail struct {
+	*m.Task
+	Attempts []*Attempt `json:"attempts"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type moveRequest struct {
+	Step string `json:"step"`
+	// Force moves the task without checking the step, see ForceMoveTask
+	Force bool `json:"force"`
+}
+
+type enqueueRequest struct {
+	Name     string          `json:"name"`
+	Args     json.RawMessage `json:"args"`
+	TodoDate time.Time       `json:"todo_date"`
+}
+
+// NewAPIHandler returns an http.Handler exposing the queue as JSON.
+// Paths are relative to the handler so it can be mounted under any prefix
+// with http.StripPrefix. Middlewares are applied in order, the first one
+// being the outermost.
+//
+//	GET  /tasks?name=&status=&after=&before=&limit=&offset=
+//	POST /tasks
+//	GET  /tasks/{id}
+//	POST /tasks/{id}/retry
+//	POST /tasks/{id}/cancel
+//	POST /tasks/{id}/move
+//	GET  /stats
+//
+// The handler doesn't know the task definitions, a move must be forced. Use
+// Scheduler.APIHandler to check the steps of the moves.
+func NewAPIHandler(middlewares ...Middleware) http.Handler {
+	return newAPIHandler(nil, middlewares)
+}
+
+// APIHandler is NewAPIHandler checking the steps of the moves against the
+// definitions of the scheduler, like Scheduler.MoveTask
+func (s *Scheduler) APIHandler(middlewares ...Middleware) http.Handler {
+	return newAPIHandler(s, middlewares)
+}
+
+func newAPIHandler(s *Scheduler, middlewares []Middleware) http.Handler {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveAPI(w, r, s)
+	})
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// serveAPI serves a request of the API, s is nil when the handler was not
+// created by a scheduler
+func serveAPI(w http.ResponseWriter, r *http.Request, s *Scheduler) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "tasks":
+		switch r.Method {
+		case http.MethodGet:
+			apiListTasks(w, r)
+		case http.MethodPost:
+			apiEnqueue(w, r)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) == 2 && parts[0] == "tasks":
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		apiGetTask(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "tasks" && (parts[2] == "retry" || parts[2] == "cancel"):
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		action := RetryTask
+		if parts[2] == "cancel" {
+			action = CancelTask
+		}
+		task, err := action(r.Context(), parts[1])
+		if err != nil {
+			writeAPIErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, task)
+	case len(parts) == 3 && parts[0] == "tasks" && parts[2] == "move":
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		apiMoveTask(w, r, s, parts[1])
+	case len(parts) == 1 && parts[0] == "stats":
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		stats, err := Stats(r.Context())
+		if err != nil {
+			writeAPIErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func apiListTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := TaskFilter{
+		Name:   q.Get("name"),
+		Status: q.Get("status"),
+		Limit:  apiDefaultLimit,
+	}
+
+	var err error
+	if v := q.Get("after"); v != "" {
+		if f.After, err = time.Parse(time.RFC3339, v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid after date")
+			return
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		if f.Before, err = time.Parse(time.RFC3339, v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid before date")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > apiMaxLimit {
+			writeAPIError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+
+	tasks, err := ListTasks(r.Context(), f)
+	if err != nil {
+		writeAPIErr(w, err)
+		return
+	}
+	if tasks == nil {
+		tasks = m.TaskSlice{}
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+func apiGetTask(w http.ResponseWriter, r *http.Request, id string) {
+	task, err := GetTask(r.Context(), id)
+	if err != nil {
+		writeAPIErr(w, err)
+		return
+	}
+
+	attempts, err := GetAttempts(r.Context(), id)
+	if err != nil {
+		writeAPIErr(w, err)
+		return
+	}
+	if attempts == nil {
+		attempts = []*Attempt{}
+	}
+
+	writeJSON(w, http.StatusOK, TaskDetail{Task: task, Attempts: attempts})
+}
+
+func apiMoveTask(w http.ResponseWriter, r *http.Request, s *Scheduler, id string) {
+	var req moveRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var task *m.Task
+	switch {
+	case req.Force:
+		task, err = ForceMoveTask(r.Context(), id, req.Step)
+	case s != nil:
+		task, err = s.MoveTask(r.Context(), id, req.Step)
+	default:
+		writeAPIError(w, http.StatusBadRequest, "the step cannot be checked without the task definitions, move with force")
+		return
+	}
+	if err != nil {
+		writeAPIErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func apiEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req enqueueRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	p := EnqueueParams{
+		Name:     req.Name,
+		TodoDate: req.TodoDate,
+	}
+	if len(req.Args) > 0 {
+		p.Args = req.Args
+	}
+
+	task, err := Enqueue(r.Context(), p)
+	if err != nil {
+		writeAPIErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, task)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// writeAPIErr maps typed errors to http status codes
+func writeAPIErr(w http.ResponseWriter, err error) {
+	var defErr *DefinitionError
+	switch {
+	case err == ErrTaskNotFound:
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case err == ErrInvalidStatus:
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case err == ErrMissingTaskName, err == ErrMissingStepName, errors.As(err, &defErr):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+	}
+}
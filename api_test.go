@@ -0,0 +1,85 @@
+package tasker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIRouting(t *testing.T) {
+	h := http.StripPrefix("/admin", NewAPIHandler())
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/admin/unknown", "", http.StatusNotFound},
+		{http.MethodDelete, "/admin/tasks", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/tasks/c9f51923-293a-4e3b-a49f-cccd71db4679", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/tasks/c9f51923-293a-4e3b-a49f-cccd71db4679/retry", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/tasks?limit=0", "", http.StatusBadRequest},
+		{http.MethodGet, "/admin/tasks?after=yesterday", "", http.StatusBadRequest},
+		{http.MethodGet, "/admin/tasks/not-an-id", "", http.StatusNotFound},
+		{http.MethodPost, "/admin/tasks/not-an-id/cancel", "", http.StatusNotFound},
+		{http.MethodGet, "/admin/tasks/c9f51923-293a-4e3b-a49f-cccd71db4679/move", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/tasks/c9f51923-293a-4e3b-a49f-cccd71db4679/move", "{", http.StatusBadRequest},
+		{http.MethodPost, "/admin/tasks", "{", http.StatusBadRequest},
+		{http.MethodPost, "/admin/tasks", `{"args":{"a":1}}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.status, rec.Code)
+		}
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: expected a json response", test.method, test.path)
+		}
+	}
+}
+
+func TestAPIMiddlewares(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	h := NewAPIHandler(mw("first"), mw("second"), deny)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Auth middleware should reject the request, got %d", rec.Code)
+	}
+	if strings.Join(order, ",") != "first,second" {
+		t.Errorf("Middlewares applied in wrong order: %v", order)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	req.Header.Set("Authorization", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Authorized request should reach the api, got %d", rec.Code)
+	}
+}
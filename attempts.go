@@ -0,0 +1,45 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/volatiletech/null"
+)
+
+// Attempt is one execution of a step of a task
+type Attempt struct {
+	ID         string      `boil:"id" json:"id"`
+	TaskID     string      `boil:"task_id" json:"task_id"`
+	Step       string      `boil:"step" json:"step"`
+	Retry      int         `boil:"retry" json:"retry"`
+	StartedAt  time.Time   `boil:"started_at" json:"started_at"`
+	FinishedAt time.Time   `boil:"finished_at" json:"finished_at"`
+	Error      null.String `boil:"error" json:"error"`
+}
+
+// GetAttempts returns the attempt history of a task, oldest first
+func GetAttempts(ctx context.Context, taskID string) ([]*Attempt, error) {
+	return defaultStore().Attempts(ctx, taskID)
+}
+
+// recordAttempt stores the outcome of a step, the history is informative only
+// so a failure to write it does not fail the task
+func (t *Task) recordAttempt(step string, startedAt time.Time, stepErr error) {
+	a := &Attempt{
+		TaskID:     t.UserTask.ID,
+		Step:       step,
+		Retry:      t.UserTask.Retry,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	if stepErr != nil {
+		a.Error = null.StringFrom(stepErr.Error())
+	}
+
+	err := t.UserTask.store().AddAttempt(t.Context(), a)
+	if err != nil {
+		fmt.Printf("tasker: cannot record attempt of step %s: %s\n", step, err.Error())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// dbConfig is one environment of database.yml
+type dbConfig struct {
+	Dialect  string `yaml:"dialect"`
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	SSLMode  string `yaml:"sslmode"`
+	Pool     int    `yaml:"pool"`
+	URL      string `yaml:"url"`
+}
+
+// DSN returns the connection string usable by lib/pq
+func (c dbConfig) DSN() string {
+	if c.URL != "" {
+		return c.URL
+	}
+
+	dsn := fmt.Sprintf("dbname=%s", c.Database)
+	if c.Host != "" {
+		dsn += fmt.Sprintf(" host=%s", c.Host)
+	}
+	if c.Port != 0 {
+		dsn += fmt.Sprintf(" port=%d", c.Port)
+	}
+	if c.User != "" {
+		dsn += fmt.Sprintf(" user=%s", c.User)
+	}
+	if c.Password != "" {
+		dsn += fmt.Sprintf(" password=%s", c.Password)
+	}
+
+	sslmode := c.SSLMode
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+	return dsn + " sslmode=" + sslmode
+}
+
+// loadDatabaseYML reads the given environment of a database.yml file,
+// the file is rendered as a template first so envOr can be used
+func loadDatabaseYML(path, env string) (*dbConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := template.New(path).Funcs(template.FuncMap{
+		"envOr": func(key, def string) string {
+			if v, ok := os.LookupEnv(key); ok {
+				return v
+			}
+			return def
+		},
+	}).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var rendered bytes.Buffer
+	err = tpl.Execute(&rendered, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	envs := map[string]dbConfig{}
+	err = yaml.Unmarshal(rendered.Bytes(), &envs)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := envs[env]
+	if !ok {
+		return nil, fmt.Errorf("environment %q not found in %s", env, path)
+	}
+
+	if c.Dialect != "" && c.Dialect != "postgres" {
+		return nil, fmt.Errorf("unsupported dialect %q", c.Dialect)
+	}
+
+	return &c, nil
+}
+
+// loadSQLBoilerYML reads the psql section of a sqlboiler.yml file
+func loadSQLBoilerYML(path string) (*dbConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	v.SetDefault("psql.port", 5432)
+	v.SetDefault("psql.sslmode", "require")
+
+	return &dbConfig{
+		Database: v.GetString("psql.dbname"),
+		Host:     v.GetString("psql.host"),
+		Port:     v.GetInt("psql.port"),
+		User:     v.GetString("psql.user"),
+		Password: v.GetString("psql.pass"),
+		SSLMode:  v.GetString("psql.sslmode"),
+	}, nil
+}
+
+// loadConfig picks the connection settings from, in order, an explicit url,
+// database.yml and sqlboiler.yml
+func loadConfig(url, databaseYML, env, sqlboilerYML string) (*dbConfig, error) {
+	if url != "" {
+		return &dbConfig{URL: url}, nil
+	}
+
+	if _, err := os.Stat(databaseYML); err == nil {
+		return loadDatabaseYML(databaseYML, env)
+	}
+
+	if _, err := os.Stat(sqlboilerYML); err == nil {
+		return loadSQLBoilerYML(sqlboilerYML)
+	}
+
+	return nil, fmt.Errorf("no database configuration found, tried %s and %s", databaseYML, sqlboilerYML)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDatabaseYML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tasker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "database.yml", `
+development:
+  dialect: postgres
+  database: tasker_development
+  user: postgres
+  password: postgres
+  host: 127.0.0.1
+  pool: 5
+
+test:
+  url: {{envOr "TASKER_TEST_URL" "postgres://localhost/tasker_test"}}
+`)
+
+	conf, err := loadDatabaseYML(path, "development")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DSN() != "dbname=tasker_development host=127.0.0.1 user=postgres password=postgres sslmode=disable" {
+		t.Errorf("Unexpected dsn %s", conf.DSN())
+	}
+	if conf.Pool != 5 {
+		t.Errorf("Expected pool of 5, got %d", conf.Pool)
+	}
+
+	conf, err = loadDatabaseYML(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DSN() != "postgres://localhost/tasker_test" {
+		t.Errorf("Unexpected default url %s", conf.DSN())
+	}
+
+	os.Setenv("TASKER_TEST_URL", "postgres://db/other")
+	defer os.Unsetenv("TASKER_TEST_URL")
+	conf, err = loadDatabaseYML(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DSN() != "postgres://db/other" {
+		t.Errorf("Url should be read from env, got %s", conf.DSN())
+	}
+
+	_, err = loadDatabaseYML(path, "production")
+	if err == nil {
+		t.Errorf("Should fail on unknown environment")
+	}
+}
+
+func TestLoadSQLBoilerYML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tasker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "sqlboiler.yml", `
+psql:
+  dbname:  "test"
+  host:    "localhost"
+  port:    5433
+  user:    "root"
+  pass:    "root"
+  sslmode: "disable"
+`)
+
+	conf, err := loadConfig("", filepath.Join(dir, "database.yml"), "development", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DSN() != "dbname=test host=localhost port=5433 user=root password=root sslmode=disable" {
+		t.Errorf("Unexpected dsn %s", conf.DSN())
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	args := fs.String("args", "", "")
+	output := fs.String("o", "table", "")
+
+	positional, err := parseInterspersed(fs, []string{"send_mail", "--args", `{"a":1}`, "-o", "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{"send_mail"}) {
+		t.Errorf("Unexpected positional arguments %v", positional)
+	}
+	if *args != `{"a":1}` || *output != "json" {
+		t.Errorf("Flags after positional arguments should be parsed")
+	}
+}
@@ -0,0 +1,299 @@
+// Command tasker inspects and operates a tasker queue
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	// Import pq globally
+	_ "github.com/lib/pq"
+
+	"github.com/wesraph/tasker"
+)
+
+const usage = `Usage: tasker [global flags] <command> [flags]
+
+Commands:
+  list                     list tasks
+  show <id>                show a task
+  retry <id>               put a failed or cancelled task back in the queue
+  cancel <id>              cancel a pending task
+  move -force <id> <step>  put a pending or failed task back in the queue at a step
+  enqueue <name>           enqueue a new task
+  stats                    show queue statistics
+  migrate                  create or upgrade the tasker schema
+
+Global flags:
+`
+
+type command struct {
+	flags *flag.FlagSet
+	run   func(ctx context.Context, args []string) error
+}
+
+type app struct {
+	db     *sql.DB
+	store  *tasker.PostgresStore
+	out    printer
+	output string
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tasker:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	global := flag.NewFlagSet("tasker", flag.ContinueOnError)
+	url := global.String("url", os.Getenv("DATABASE_URL"), "database url, takes precedence over config files")
+	databaseYML := global.String("database", "database.yml", "path to database.yml")
+	env := global.String("env", envOr("TASKER_ENV", "development"), "database.yml environment")
+	sqlboilerYML := global.String("sqlboiler", "sqlboiler.yml", "path to sqlboiler.yml, used when database.yml is missing")
+	schema := global.String("schema", "", "postgres schema of the tasker tables")
+	table := global.String("table", "", "name of the tasks table, tasks by default")
+	global.Usage = func() {
+		fmt.Fprint(global.Output(), usage)
+		global.PrintDefaults()
+	}
+
+	err := global.Parse(args)
+	if err != nil {
+		return err
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	a := &app{out: printer{w: w}}
+	commands := a.commands()
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		global.Usage()
+		return fmt.Errorf("unknown command %q", global.Arg(0))
+	}
+
+	cmd.flags.StringVar(&a.output, "o", outputTable, "output format, table or json")
+	positional, err := parseInterspersed(cmd.flags, global.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if a.output != outputTable && a.output != outputJSON {
+		return fmt.Errorf("unknown output format %q", a.output)
+	}
+	a.out.format = a.output
+
+	conf, err := loadConfig(*url, *databaseYML, *env, *sqlboilerYML)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", conf.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if conf.Pool > 0 {
+		db.SetMaxOpenConns(conf.Pool)
+	}
+
+	a.db = db
+	a.store = tasker.NewPostgresStore(db)
+	a.store.Tables = tasker.Tables{Schema: *schema, Tasks: *table}
+	tasker.Init(db)
+	tasker.InitStore(a.store)
+	return cmd.run(context.Background(), positional)
+}
+
+func (a *app) commands() map[string]*command {
+	list := flag.NewFlagSet("list", flag.ContinueOnError)
+	listName := list.String("name", "", "filter by task name")
+	listStatus := list.String("status", "", "filter by status")
+	listAfter := list.String("after", "", "only tasks due after this date (RFC3339)")
+	listBefore := list.String("before", "", "only tasks due before this date (RFC3339)")
+	listLimit := list.Int("limit", 50, "maximum number of tasks")
+	listOffset := list.Int("offset", 0, "number of tasks to skip")
+
+	move := flag.NewFlagSet("move", flag.ContinueOnError)
+	moveForce := move.Bool("force", false, "move without checking the step, the CLI does not know the task definitions")
+
+	enqueue := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	enqueueArgs := enqueue.String("args", "", "task arguments as JSON")
+	enqueueAt := enqueue.String("at", "", "date at which the task should run (RFC3339)")
+	enqueueDedup := enqueue.String("dedup", "", "dedup key, the task is not enqueued twice with the same key")
+
+	return map[string]*command{
+		"list": {
+			flags: list,
+			run: func(ctx context.Context, args []string) error {
+				f := tasker.TaskFilter{
+					Name:   *listName,
+					Status: *listStatus,
+					Limit:  *listLimit,
+					Offset: *listOffset,
+				}
+				var err error
+				if f.After, err = parseDate(*listAfter); err != nil {
+					return err
+				}
+				if f.Before, err = parseDate(*listBefore); err != nil {
+					return err
+				}
+
+				tasks, err := tasker.ListTasks(ctx, f)
+				if err != nil {
+					return err
+				}
+				return a.out.tasks(tasks)
+			},
+		},
+		"show": {
+			flags: flag.NewFlagSet("show", flag.ContinueOnError),
+			run: withID(func(ctx context.Context, id string) error {
+				task, err := tasker.GetTask(ctx, id)
+				if err != nil {
+					return err
+				}
+				attempts, err := tasker.GetAttempts(ctx, id)
+				if err != nil {
+					return err
+				}
+				return a.out.taskDetail(tasker.TaskDetail{Task: task, Attempts: attempts})
+			}),
+		},
+		"retry": {
+			flags: flag.NewFlagSet("retry", flag.ContinueOnError),
+			run: withID(func(ctx context.Context, id string) error {
+				task, err := tasker.RetryTask(ctx, id)
+				if err != nil {
+					return err
+				}
+				return a.out.task(task)
+			}),
+		},
+		"cancel": {
+			flags: flag.NewFlagSet("cancel", flag.ContinueOnError),
+			run: withID(func(ctx context.Context, id string) error {
+				task, err := tasker.CancelTask(ctx, id)
+				if err != nil {
+					return err
+				}
+				return a.out.task(task)
+			}),
+		},
+		"move": {
+			flags: move,
+			run: func(ctx context.Context, args []string) error {
+				if len(args) != 2 {
+					return fmt.Errorf("move expects a task id and a step")
+				}
+				if !*moveForce {
+					return fmt.Errorf("the step cannot be checked without the task definitions, move with -force")
+				}
+				task, err := tasker.ForceMoveTask(ctx, args[0], args[1])
+				if err != nil {
+					return err
+				}
+				return a.out.task(task)
+			},
+		},
+		"enqueue": {
+			flags: enqueue,
+			run: func(ctx context.Context, args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("enqueue expects exactly one task name")
+				}
+
+				p := tasker.EnqueueParams{Name: args[0], DedupKey: *enqueueDedup}
+				if *enqueueArgs != "" {
+					if !json.Valid([]byte(*enqueueArgs)) {
+						return fmt.Errorf("--args is not valid JSON")
+					}
+					p.Args = json.RawMessage(*enqueueArgs)
+				}
+				var err error
+				if p.TodoDate, err = parseDate(*enqueueAt); err != nil {
+					return err
+				}
+
+				task, err := tasker.Enqueue(ctx, p)
+				if err != nil {
+					return err
+				}
+				return a.out.task(task)
+			},
+		},
+		"stats": {
+			flags: flag.NewFlagSet("stats", flag.ContinueOnError),
+			run: func(ctx context.Context, args []string) error {
+				stats, err := tasker.Stats(ctx)
+				if err != nil {
+					return err
+				}
+				return a.out.stats(stats)
+			},
+		},
+		"migrate": {
+			flags: flag.NewFlagSet("migrate", flag.ContinueOnError),
+			run: func(ctx context.Context, args []string) error {
+				err := a.store.Migrate(ctx)
+				if err != nil {
+					return err
+				}
+				version, err := a.store.SchemaVersion(ctx)
+				if err != nil {
+					return err
+				}
+				return a.out.schemaVersion(version)
+			},
+		},
+	}
+}
+
+func withID(fn func(ctx context.Context, id string) error) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("expected exactly one task id")
+		}
+		return fn(ctx, args[0])
+	}
+}
+
+// parseInterspersed parses flags placed before or after positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
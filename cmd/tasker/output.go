@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wesraph/tasker"
+	m "github.com/wesraph/tasker/models"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func (p printer) json(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (p printer) tasks(tasks m.TaskSlice) error {
+	if p.format == outputJSON {
+		if tasks == nil {
+			tasks = m.TaskSlice{}
+		}
+		return p.json(tasks)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tSTEP\tRETRY\tTODO DATE\tCREATED AT")
+	for _, t := range tasks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			t.ID, t.Name, t.Status, t.ActualStep, t.Retry,
+			t.TodoDate.Format(timeFormat), t.CreatedAt.Format(timeFormat))
+	}
+	return tw.Flush()
+}
+
+func (p printer) task(t *m.Task) error {
+	if p.format == outputJSON {
+		return p.json(t)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", t.ID)
+	fmt.Fprintf(tw, "Name\t%s\n", t.Name)
+	fmt.Fprintf(tw, "Version\t%d\n", t.Version)
+	fmt.Fprintf(tw, "Status\t%s\n", t.Status)
+	fmt.Fprintf(tw, "Step\t%s\n", t.ActualStep)
+	fmt.Fprintf(tw, "Queue\t%s\n", formatQueue(t.Queue))
+	fmt.Fprintf(tw, "Progress\t%s\n", formatProgress(t))
+	fmt.Fprintf(tw, "Retry\t%d\n", t.Retry)
+	fmt.Fprintf(tw, "Todo date\t%s\n", t.TodoDate.Format(timeFormat))
+	fmt.Fprintf(tw, "Created at\t%s\n", t.CreatedAt.Format(timeFormat))
+	fmt.Fprintf(tw, "Args\t%s\n", jsonOrNull(t.UserArgs.JSON, t.UserArgs.Valid))
+	fmt.Fprintf(tw, "Buffer\t%s\n", jsonOrNull(t.UserBuffer.JSON, t.UserBuffer.Valid))
+	fmt.Fprintf(tw, "Result\t%s\n", jsonOrNull(t.Result.JSON, t.Result.Valid))
+	return tw.Flush()
+}
+
+func (p printer) taskDetail(d tasker.TaskDetail) error {
+	if p.format == outputJSON {
+		if d.Attempts == nil {
+			d.Attempts = []*tasker.Attempt{}
+		}
+		return p.json(d)
+	}
+
+	err := p.task(d.Task)
+	if err != nil {
+		return err
+	}
+	if len(d.Attempts) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(p.w)
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRETRY\tSTARTED AT\tDURATION\tERROR")
+	for _, a := range d.Attempts {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n",
+			a.Step, a.Retry, a.StartedAt.Format(timeFormat),
+			a.FinishedAt.Sub(a.StartedAt).Round(time.Millisecond), a.Error.String)
+	}
+	return tw.Flush()
+}
+
+func (p printer) stats(s *tasker.QueueStats) error {
+	if p.format == outputJSON {
+		return p.json(s)
+	}
+
+	statuses := make([]string, 0, len(s.ByStatus))
+	for status := range s.ByStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCOUNT")
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "%s\t%d\n", status, s.ByStatus[status])
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "NAME\tSTATUS\tCOUNT")
+	for _, c := range s.ByName {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", c.Name, c.Status, c.Count)
+	}
+	fmt.Fprintln(tw)
+
+	if s.OldestTodo != nil {
+		fmt.Fprintf(tw, "Oldest todo\t%s (%s ago)\n", s.OldestTodo.Format(timeFormat), time.Since(*s.OldestTodo).Round(time.Second))
+	} else {
+		fmt.Fprintln(tw, "Oldest todo\t-")
+	}
+	return tw.Flush()
+}
+
+func formatProgress(t *m.Task) string {
+	p, err := tasker.TaskProgress(t)
+	if err != nil || p == nil {
+		return "-"
+	}
+	s := fmt.Sprintf("%.0f%% (%d/%d)", p.Percent(), p.Current, p.Total)
+	if p.Message != "" {
+		s += " " + p.Message
+	}
+	return s
+}
+
+func jsonOrNull(raw []byte, valid bool) string {
+	if !valid {
+		return "null"
+	}
+	return string(raw)
+}
+
+func (p printer) schemaVersion(version int) error {
+	if p.format == outputJSON {
+		return p.json(map[string]int{"version": version})
+	}
+
+	_, err := fmt.Fprintf(p.w, "Schema at version %d\n", version)
+	return err
+}
+
+func formatQueue(queue string) string {
+	if queue == "" {
+		return "default"
+	}
+	return queue
+}
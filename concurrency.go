@@ -0,0 +1,50 @@
+package tasker
+
+import (
+	"encoding/json"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// ConcurrencyLimit limits the number of tasks of a name running at once
+// with the same value of a field of their arguments, across all the
+// schedulers sharing the store. With Max set to 1, the tasks of a same
+// customer run one after the other while the other customers proceed.
+type ConcurrencyLimit struct {
+	// Key is the field of the user args holding the value shared by the
+	// tasks limited together, like user_address. The tasks without the field
+	// are not limited.
+	Key string
+	// Max is the number of tasks running at once for a value, 1 when 0
+	Max int
+}
+
+func (c *ConcurrencyLimit) max() int {
+	if c.Max <= 0 {
+		return 1
+	}
+	return c.Max
+}
+
+// argValue returns the text of a top level field of the user args, like
+// the ->> operator of Postgres, ok is false when the field is missing or
+// null
+func argValue(task *m.Task, key string) (value string, ok bool) {
+	if !task.UserArgs.Valid {
+		return "", false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(task.UserArgs.JSON, &fields) != nil {
+		return "", false
+	}
+	raw, ok := fields[key]
+	if !ok || string(raw) == "null" {
+		return "", false
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	return string(raw), true
+}
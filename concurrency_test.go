@@ -0,0 +1,100 @@
+package tasker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestArgValue(t *testing.T) {
+	cases := []struct {
+		args  string
+		value string
+		ok    bool
+	}{
+		{`{"user_address": "0xabc"}`, "0xabc", true},
+		{`{"user_address": 12}`, "12", true},
+		{`{"user_address": null}`, "", false},
+		{`{"other": "0xabc"}`, "", false},
+		{`[1, 2]`, "", false},
+	}
+	for _, c := range cases {
+		task := &m.Task{UserArgs: null.JSONFrom([]byte(c.args))}
+		value, ok := argValue(task, "user_address")
+		if value != c.value || ok != c.ok {
+			t.Errorf("argValue(%s) = %q, %v, expected %q, %v", c.args, value, ok, c.value, c.ok)
+		}
+	}
+}
+
+func TestSchedulerConcurrencyLimit(t *testing.T) {
+	useMemoryStore(t)
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+	step := func(t *Task) error {
+		var args struct {
+			UserAddress string `json:"user_address"`
+		}
+		err := t.UserTask.UserArgs.Unmarshal(&args)
+		if err != nil {
+			return err
+		}
+		user := args.UserAddress
+		mu.Lock()
+		running[user]++
+		if running[user] > maxRunning[user] {
+			maxRunning[user] = running[user]
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running[user]--
+		mu.Unlock()
+		return nil
+	}
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:        "transfer",
+			Steps:       []Step{{Name: "step1", Exec: step}},
+			Concurrency: &ConcurrencyLimit{Key: "user_address"},
+		}},
+		Workers: 4,
+	}
+
+	var params []EnqueueParams
+	for i := 0; i < 3; i++ {
+		for _, user := range []string{"a", "b"} {
+			params = append(params, EnqueueParams{
+				Name:     "transfer",
+				Args:     map[string]string{"user_address": user},
+				TodoDate: time.Now().Add(-time.Second),
+			})
+		}
+	}
+	_, err := s.EnqueueMany(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{2, 2, 2, 0} {
+		n, err := s.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Fatalf("Expected %d executed tasks, got %d", expected, n)
+		}
+	}
+	if maxRunning["a"] != 1 || maxRunning["b"] != 1 {
+		t.Errorf("Expected one task at once per user, got %v", maxRunning)
+	}
+}
@@ -0,0 +1,449 @@
+package tasker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+const (
+	dashboardPageSize = 50
+	dashboardRefresh  = 10
+	// dashboardProgressPoll is the delay between two reads of a task whose
+	// progress is streamed
+	dashboardProgressPoll = time.Second
+)
+
+// The forms of the dashboard send back the token of the CSRF cookie, a page
+// of another site can post a form but cannot read the cookie
+const (
+	dashboardCSRFCookie = "tasker_csrf"
+	dashboardCSRFField  = "csrf_token"
+)
+
+//go:embed dashboard/*.html
+var dashboardFS embed.FS
+
+var dashboardFuncs = template.FuncMap{
+	"date": func(t interface{}) string {
+		switch v := t.(type) {
+		case time.Time:
+			return v.Format("2006-01-02 15:04:05")
+		case *time.Time:
+			return v.Format("2006-01-02 15:04:05")
+		}
+		return ""
+	},
+	"hour": func(t time.Time) string {
+		return t.Format("15h")
+	},
+	"since": func(t *time.Time) string {
+		return time.Since(*t).Round(time.Second).String()
+	},
+	"duration": func(from, to time.Time) string {
+		return to.Sub(from).Round(time.Millisecond).String()
+	},
+	"json": func(j null.JSON) string {
+		if !j.Valid {
+			return "null"
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, j.JSON, "", "  "); err != nil {
+			return string(j.JSON)
+		}
+		return buf.String()
+	},
+	"retryable": func(status string) bool {
+		return status == m.TaskStatusError || status == m.TaskStatusCancelled
+	},
+	"progress": func(task *m.Task) *Progress {
+		p, _ := TaskProgress(task)
+		return p
+	},
+	"row": func(root, csrf, id string) taskRow {
+		return taskRow{Root: root, CSRF: csrf, ID: id}
+	},
+}
+
+var dashboardTemplates = map[string]*template.Template{
+	"overview": parseDashboardPage("overview.html"),
+	"tasks":    parseDashboardPage("tasks.html"),
+	"task":     parseDashboardPage("task.html"),
+}
+
+func parseDashboardPage(page string) *template.Template {
+	return template.Must(template.New(page).Funcs(dashboardFuncs).ParseFS(dashboardFS, "dashboard/layout.html", "dashboard/"+page))
+}
+
+type taskRow struct {
+	Root string
+	CSRF string
+	ID   string
+}
+
+type taskList struct {
+	Root  string
+	CSRF  string
+	Tasks m.TaskSlice
+}
+
+type statusCount struct {
+	Status string
+	Count  int64
+}
+
+type nameCounts struct {
+	Name   string
+	Counts []int64
+}
+
+type throughputBar struct {
+	ThroughputBucket
+	SucceededPct float64
+	FailedPct    float64
+}
+
+type page struct {
+	Root    string
+	Refresh int
+	CSRF    string
+}
+
+type overviewPage struct {
+	page
+	Stats       *QueueStats
+	Statuses    []statusCount
+	StatusNames []string
+	Names       []nameCounts
+	Throughput  []throughputBar
+	Running     taskList
+	Errored     taskList
+}
+
+type tasksPage struct {
+	page
+	Filter     TaskFilter
+	List       taskList
+	NextOffset int
+}
+
+type taskPage struct {
+	page
+	Task     *m.Task
+	Attempts []*Attempt
+}
+
+// NewDashboardHandler returns an http.Handler serving a html dashboard of the
+// queue. Like NewAPIHandler, it can be mounted under any prefix with
+// http.StripPrefix and protected by middlewares.
+func NewDashboardHandler(middlewares ...Middleware) http.Handler {
+	var h http.Handler = http.HandlerFunc(serveDashboard)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	// Links are relative, the root must end with a slash to resolve them
+	if r.URL.Path == "" {
+		w.Header().Set("Location", strings.SplitN(r.RequestURI, "?", 2)[0]+"/")
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		dashboardOverview(w, r)
+	case len(parts) == 1 && parts[0] == "tasks" && r.Method == http.MethodGet:
+		dashboardTasks(w, r)
+	case len(parts) == 2 && parts[0] == "tasks" && r.Method == http.MethodGet:
+		dashboardTask(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "tasks" && parts[2] == "progress" && r.Method == http.MethodGet:
+		dashboardProgress(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "tasks" && parts[2] == "retry" && r.Method == http.MethodPost:
+		if !validCSRF(r) {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		_, err := RetryTask(r.Context(), parts[1])
+		if err != nil {
+			dashboardError(w, err)
+			return
+		}
+		w.Header().Set("Location", "../"+parts[1])
+		w.WriteHeader(http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func dashboardOverview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	csrf, err := csrfToken(w, r)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	data := overviewPage{
+		page:        page{Root: "./", Refresh: dashboardRefresh, CSRF: csrf},
+		StatusNames: taskStatuses,
+	}
+
+	data.Stats, err = Stats(ctx)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	for _, status := range taskStatuses {
+		data.Statuses = append(data.Statuses, statusCount{Status: status, Count: data.Stats.ByStatus[status]})
+	}
+	data.Names = countsByName(data.Stats.ByName)
+
+	now := time.Now()
+	buckets, err := Throughput(ctx, now.Add(-23*time.Hour).Truncate(time.Hour))
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	data.Throughput = throughputBars(buckets, now)
+
+	data.Running = taskList{Root: data.Root, CSRF: csrf}
+	data.Running.Tasks, err = ListTasks(ctx, TaskFilter{Status: m.TaskStatusDoing, Limit: dashboardPageSize})
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+
+	data.Errored = taskList{Root: data.Root, CSRF: csrf}
+	data.Errored.Tasks, err = ListTasks(ctx, TaskFilter{Status: m.TaskStatusError, Limit: dashboardPageSize})
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+
+	renderDashboard(w, "overview", data)
+}
+
+func dashboardTasks(w http.ResponseWriter, r *http.Request) {
+	csrf, err := csrfToken(w, r)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	data := tasksPage{
+		page: page{Root: "./", CSRF: csrf},
+		Filter: TaskFilter{
+			Name:   q.Get("name"),
+			Status: q.Get("status"),
+			Limit:  dashboardPageSize,
+		},
+	}
+	data.Filter.Offset, _ = strconv.Atoi(q.Get("offset"))
+	if data.Filter.Offset < 0 {
+		data.Filter.Offset = 0
+	}
+
+	data.List = taskList{Root: data.Root, CSRF: csrf}
+	data.List.Tasks, err = ListTasks(r.Context(), data.Filter)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	if len(data.List.Tasks) == dashboardPageSize {
+		data.NextOffset = data.Filter.Offset + dashboardPageSize
+	}
+
+	renderDashboard(w, "tasks", data)
+}
+
+func dashboardTask(w http.ResponseWriter, r *http.Request, id string) {
+	csrf, err := csrfToken(w, r)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	data := taskPage{page: page{Root: "../", CSRF: csrf}}
+
+	data.Task, err = GetTask(r.Context(), id)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+	data.Attempts, err = GetAttempts(r.Context(), id)
+	if err != nil {
+		dashboardError(w, err)
+		return
+	}
+
+	renderDashboard(w, "task", data)
+}
+
+type progressEvent struct {
+	Status   string    `json:"status"`
+	Step     string    `json:"step"`
+	Progress *Progress `json:"progress"`
+}
+
+// dashboardProgress streams the progress of a task as server-sent events
+// until the task stops running
+func dashboardProgress(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var last []byte
+	for {
+		task, err := GetTask(r.Context(), id)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		progress, _ := TaskProgress(task)
+
+		event, err := json.Marshal(progressEvent{Status: task.Status, Step: task.ActualStep, Progress: progress})
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(event, last) {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			flusher.Flush()
+			last = event
+		}
+		if task.Status != m.TaskStatusDoing && task.Status != m.TaskStatusTodo {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(dashboardProgressPoll):
+		}
+	}
+}
+
+// countsByName pivots the per name counts into one row per name
+func countsByName(counts []NameStatusCount) []nameCounts {
+	index := map[string]int{}
+	var rows []nameCounts
+	for _, c := range counts {
+		i, ok := index[c.Name]
+		if !ok {
+			i = len(rows)
+			index[c.Name] = i
+			rows = append(rows, nameCounts{Name: c.Name, Counts: make([]int64, len(taskStatuses))})
+		}
+		for j, status := range taskStatuses {
+			if status == c.Status {
+				rows[i].Counts[j] += c.Count
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows
+}
+
+// throughputBars fills the 24 hours up to now, scaled on the busiest hour
+func throughputBars(buckets []*ThroughputBucket, now time.Time) []throughputBar {
+	byHour := map[int64]*ThroughputBucket{}
+	var max int64
+	for _, b := range buckets {
+		byHour[b.Hour.Unix()] = b
+		if total := b.Succeeded + b.Failed; total > max {
+			max = total
+		}
+	}
+
+	bars := make([]throughputBar, 24)
+	start := now.Add(-23 * time.Hour).Truncate(time.Hour)
+	for i := range bars {
+		hour := start.Add(time.Duration(i) * time.Hour)
+		bars[i].Hour = hour
+		if b, ok := byHour[hour.Unix()]; ok {
+			bars[i].Succeeded = b.Succeeded
+			bars[i].Failed = b.Failed
+		}
+		if max > 0 {
+			bars[i].SucceededPct = float64(bars[i].Succeeded) * 100 / float64(max)
+			bars[i].FailedPct = float64(bars[i].Failed) * 100 / float64(max)
+		}
+	}
+	return bars
+}
+
+func renderDashboard(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	err := dashboardTemplates[name].ExecuteTemplate(&buf, "layout", data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
+
+// csrfToken returns the CSRF token of the browser, a new one is set when
+// it has none
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(dashboardCSRFCookie); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardCSRFCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// validCSRF is true when the posted form sends back the token of the cookie
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(dashboardCSRFCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue(dashboardCSRFField))) == 1
+}
+
+func dashboardError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrTaskNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrInvalidStatus:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
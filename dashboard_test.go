@@ -0,0 +1,197 @@
+package tasker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestDashboardTemplates(t *testing.T) {
+	now := time.Now()
+	task := &m.Task{
+		ID:         "c9f51923-293a-4e3b-a49f-cccd71db4679",
+		Name:       "test",
+		ActualStep: "step2",
+		Status:     m.TaskStatusError,
+		CreatedAt:  now,
+		TodoDate:   now,
+		UserArgs:   null.JSONFrom([]byte(`{"user_address":"salut"}`)),
+		Progress:   null.JSONFrom([]byte(`{"current":63,"total":100,"message":"copying rows"}`)),
+	}
+
+	pages := map[string]interface{}{
+		"overview": overviewPage{
+			page:        page{Root: "./", Refresh: dashboardRefresh},
+			Stats:       &QueueStats{ByStatus: map[string]int64{m.TaskStatusError: 1}, OldestTodo: &now},
+			StatusNames: taskStatuses,
+			Names:       countsByName([]NameStatusCount{{Name: "test", Status: m.TaskStatusError, Count: 1}}),
+			Throughput:  throughputBars([]*ThroughputBucket{{Hour: now.Truncate(time.Hour), Succeeded: 3, Failed: 1}}, now),
+			Errored:     taskList{Root: "./", Tasks: m.TaskSlice{task}},
+		},
+		"tasks": tasksPage{
+			page:   page{Root: "./"},
+			Filter: TaskFilter{Status: m.TaskStatusError},
+			List:   taskList{Root: "./", Tasks: m.TaskSlice{task}},
+		},
+		"task": taskPage{
+			page: page{Root: "../"},
+			Task: task,
+			Attempts: []*Attempt{
+				{Step: "step2", StartedAt: now, FinishedAt: now, Error: null.StringFrom("test failing task")},
+			},
+		},
+	}
+
+	for name, data := range pages {
+		rec := httptest.NewRecorder()
+		renderDashboard(rec, name, data)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Cannot render %s: %s", name, rec.Body.String())
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), "tasks/c9f51923-293a-4e3b-a49f-cccd71db4679/retry") {
+			t.Errorf("Page %s should offer to retry the errored task", name)
+		}
+		if !strings.Contains(rec.Body.String(), "63%") {
+			t.Errorf("Page %s should show the progress of the task", name)
+		}
+	}
+}
+
+func TestDashboardRouting(t *testing.T) {
+	h := http.StripPrefix("/dashboard", NewDashboardHandler())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/dashboard/" {
+		t.Errorf("Root without trailing slash should redirect, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected not found, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard/tasks/not-an-id", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected not found for invalid id, got %d", rec.Code)
+	}
+}
+
+func TestThroughputBars(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 30, 0, 0, time.UTC)
+	bars := throughputBars([]*ThroughputBucket{
+		{Hour: time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC), Succeeded: 4},
+		{Hour: time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC), Succeeded: 1, Failed: 1},
+	}, now)
+
+	if len(bars) != 24 {
+		t.Fatalf("Expected 24 bars, got %d", len(bars))
+	}
+	if bars[23].SucceededPct != 100 {
+		t.Errorf("Busiest hour should be full height, got %f", bars[23].SucceededPct)
+	}
+	if bars[21].FailedPct != 25 || bars[21].SucceededPct != 25 {
+		t.Errorf("Unexpected scaling %+v", bars[21])
+	}
+	if bars[0].Succeeded != 0 || !bars[0].Hour.Equal(time.Date(2020, 2, 29, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected first bar %+v", bars[0])
+	}
+}
+
+func TestDashboardProgress(t *testing.T) {
+	useMemoryStore(t)
+
+	task, err := Enqueue(ctx, EnqueueParams{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Status = m.TaskStatusDone
+	task.Progress = null.JSONFrom([]byte(`{"current":10,"total":10,"updated_at":"2024-01-01T00:00:00Z"}`))
+	err = defaultStore().Update(ctx, nil, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream ends with the task
+	rec := httptest.NewRecorder()
+	NewDashboardHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+task.ID+"/progress", nil))
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", rec.Header().Get("Content-Type"))
+	}
+	expected := `data: {"status":"done","step":"","progress":{"current":10,"total":10,"updated_at":"2024-01-01T00:00:00Z"}}` + "\n\n"
+	if rec.Body.String() != expected {
+		t.Errorf("Unexpected stream %q", rec.Body.String())
+	}
+}
+
+func TestDashboardRetryCSRF(t *testing.T) {
+	useMemoryStore(t)
+
+	task, err := Enqueue(ctx, EnqueueParams{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Status = m.TaskStatusError
+	err = defaultStore().Update(ctx, []string{m.TaskColumns.Status}, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewDashboardHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+task.ID, nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != dashboardCSRFCookie {
+		t.Fatalf("Expected the page to set the CSRF cookie, got %v", cookies)
+	}
+	token := cookies[0].Value
+	if !strings.Contains(rec.Body.String(), `value="`+token+`"`) {
+		t.Errorf("Expected the retry form to send the token")
+	}
+
+	retry := func(cookie, form string) int {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+task.ID+"/retry", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: dashboardCSRFCookie, Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A form posted by another site has no cookie or doesn't know its value
+	if code := retry("", ""); code != http.StatusForbidden {
+		t.Errorf("Expected a retry without token to be forbidden, got %d", code)
+	}
+	if code := retry(token, "csrf_token=guessed"); code != http.StatusForbidden {
+		t.Errorf("Expected a retry with another token to be forbidden, got %d", code)
+	}
+	saved, err := GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != m.TaskStatusError {
+		t.Errorf("Expected the task not to be retried, got %s", saved.Status)
+	}
+
+	if code := retry(token, "csrf_token="+token); code != http.StatusSeeOther {
+		t.Errorf("Expected the retry to redirect to the task, got %d", code)
+	}
+	saved, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != m.TaskStatusTodo {
+		t.Errorf("Expected the task to be retried, got %s", saved.Status)
+	}
+}
@@ -0,0 +1,173 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kr/pretty"
+	m "github.com/wesraph/tasker/models"
+)
+
+// StepHandler executes a step, or all the steps of a task when wrapped by a
+// task middleware
+type StepHandler func(t *Task) error
+
+// StepMiddleware wraps a StepHandler, it can run code before and after the
+// step and change its error
+type StepMiddleware func(next StepHandler) StepHandler
+
+// Hooks are called on the lifecycle events of a task, nil hooks are skipped
+type Hooks struct {
+	// OnEnqueue is called after a task is inserted by Scheduler.Enqueue
+	OnEnqueue func(task *m.Task)
+	// OnStart is called before the first step of an execution
+	OnStart func(t *Task)
+	// OnStepSuccess is called after each successful step
+	OnStepSuccess func(t *Task, step string)
+	// OnStepFailure is called after each failed step
+	OnStepFailure func(t *Task, step string, err error)
+	// OnRetry is called when a failed step is scheduled for another try
+	OnRetry func(t *Task, err error)
+	// OnDone is called when the last step succeeded
+	OnDone func(t *Task)
+	// OnDead is called when the task reached its max retry
+	OnDead func(t *Task, err error)
+}
+
+// Enqueue inserts a new task in the queue and calls the OnEnqueue hooks of the
+// scheduler and of the matching task, the hooks are not called for duplicates
+func (s *Scheduler) Enqueue(ctx context.Context, p EnqueueParams) (*m.Task, error) {
+	tasks, inserted, err := enqueue(ctx, s.store(), s.stampDefinitions([]EnqueueParams{p}))
+	if err != nil {
+		return nil, err
+	}
+
+	s.onEnqueue(inserted)
+	return tasks[0], nil
+}
+
+// EnqueueMany is the EnqueueMany of the scheduler store which calls the
+// OnEnqueue hooks like Enqueue
+func (s *Scheduler) EnqueueMany(ctx context.Context, params []EnqueueParams) ([]string, error) {
+	tasks, inserted, err := enqueue(ctx, s.store(), s.stampDefinitions(params))
+	if err != nil {
+		return nil, err
+	}
+
+	s.onEnqueue(inserted)
+	return taskIDs(tasks), nil
+}
+
+func (s *Scheduler) onEnqueue(tasks m.TaskSlice) {
+	// Invalid definitions are reported when the scheduler starts
+	defs, _, _ := s.definitions()
+
+	for _, task := range tasks {
+		if s.Hooks.OnEnqueue != nil {
+			s.Hooks.OnEnqueue(task)
+		}
+		if hook := defs[task.Name].Hooks.OnEnqueue; hook != nil {
+			hook(task)
+		}
+	}
+}
+
+// SetContext replaces the context returned by Context, it is meant to be used
+// by middlewares
+func (t *Task) SetContext(c context.Context) {
+	t.ctx = c
+}
+
+// chain wraps h with the scheduler then the task middlewares, the first
+// middleware being the outermost
+func chain(h StepHandler, middlewares ...[]StepMiddleware) StepHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		for j := len(middlewares[i]) - 1; j >= 0; j-- {
+			h = middlewares[i][j](h)
+		}
+	}
+	return h
+}
+
+func (t *Task) taskHandler(h StepHandler) StepHandler {
+	if t.scheduler == nil {
+		return chain(h, t.TaskMiddlewares)
+	}
+	return chain(h, t.scheduler.TaskMiddlewares, t.TaskMiddlewares)
+}
+
+func (t *Task) stepHandler(s *Step) StepHandler {
+	if t.scheduler == nil {
+		return chain(s.Exec, t.StepMiddlewares)
+	}
+	return chain(s.Exec, t.scheduler.StepMiddlewares, t.StepMiddlewares)
+}
+
+// hooks returns the scheduler hooks followed by the task hooks
+func (t *Task) hooks() []*Hooks {
+	if t.scheduler == nil {
+		return []*Hooks{&t.Hooks}
+	}
+	return []*Hooks{&t.scheduler.Hooks, &t.Hooks}
+}
+
+func (t *Task) onStart() {
+	for _, h := range t.hooks() {
+		if h.OnStart != nil {
+			t.safeHook("OnStart", func() { h.OnStart(t) })
+		}
+	}
+}
+
+func (t *Task) onStepSuccess(step string) {
+	for _, h := range t.hooks() {
+		if h.OnStepSuccess != nil {
+			t.safeHook("OnStepSuccess", func() { h.OnStepSuccess(t, step) })
+		}
+	}
+}
+
+func (t *Task) onStepFailure(step string, err error) {
+	for _, h := range t.hooks() {
+		if h.OnStepFailure != nil {
+			t.safeHook("OnStepFailure", func() { h.OnStepFailure(t, step, err) })
+		}
+	}
+}
+
+func (t *Task) onRetry(err error) {
+	for _, h := range t.hooks() {
+		if h.OnRetry != nil {
+			t.safeHook("OnRetry", func() { h.OnRetry(t, err) })
+		}
+	}
+}
+
+func (t *Task) onDone() {
+	for _, h := range t.hooks() {
+		if h.OnDone != nil {
+			t.safeHook("OnDone", func() { h.OnDone(t) })
+		}
+	}
+}
+
+func (t *Task) onDead(err error) {
+	for _, h := range t.hooks() {
+		if h.OnDead != nil {
+			t.safeHook("OnDead", func() { h.OnDead(t, err) })
+		}
+	}
+}
+
+// safeHook runs a hook of the task, a panicking hook is logged and does not
+// change the outcome of the task
+func (t *Task) safeHook(name string, hook func()) {
+	err := safeExec(func(*Task) error {
+		hook()
+		return nil
+	}, t)
+	if err != nil {
+		fmt.Printf("Hook %s of task %s panicked\n", name, t.Name)
+		pretty.Println(err)
+	}
+}
@@ -0,0 +1,113 @@
+package tasker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+type ctxKey string
+
+func TestMiddlewares(t *testing.T) {
+	var calls []string
+	mw := func(name string) StepMiddleware {
+		return func(next StepHandler) StepHandler {
+			return func(t *Task) error {
+				calls = append(calls, name+":"+t.UserTask.ActualStep)
+				return next(t)
+			}
+		}
+	}
+	withUser := func(next StepHandler) StepHandler {
+		return func(t *Task) error {
+			t.SetContext(context.WithValue(t.Context(), ctxKey("user"), "salut"))
+			return next(t)
+		}
+	}
+
+	s := &Scheduler{
+		TaskMiddlewares: []StepMiddleware{mw("scheduler-task")},
+		StepMiddlewares: []StepMiddleware{mw("scheduler-step")},
+	}
+	task := &Task{
+		Name:            "test",
+		MaxRetry:        1,
+		TaskMiddlewares: []StepMiddleware{withUser, mw("task")},
+		StepMiddlewares: []StepMiddleware{mw("step")},
+		UserTask: &UserTask{Task: &m.Task{
+			ID:         "c9f51923-293a-4e3b-a49f-cccd71db4679",
+			ActualStep: "step1",
+			Status:     m.TaskStatusTodo,
+		}},
+		Steps: []Step{{
+			Name: "step1",
+			Exec: func(t *Task) error {
+				calls = append(calls, "exec")
+				if t.Context().Value(ctxKey("user")) != "salut" {
+					calls = append(calls, "missing context")
+				}
+				return nil
+			},
+		}},
+		scheduler: s,
+	}
+
+	err := task.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "scheduler-task:step1,task:step1,scheduler-step:step1,step:step1,exec"
+	if strings.Join(calls, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(calls, ","))
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var events []string
+	record := func(prefix string) Hooks {
+		return Hooks{
+			OnStart:       func(t *Task) { events = append(events, prefix+"start") },
+			OnStepSuccess: func(t *Task, step string) { events = append(events, prefix+"success:"+step) },
+			OnStepFailure: func(t *Task, step string, err error) { events = append(events, prefix+"failure:"+step) },
+			OnRetry:       func(t *Task, err error) { events = append(events, prefix+"retry") },
+			OnDone:        func(t *Task) { events = append(events, prefix+"done") },
+			OnDead:        func(t *Task, err error) { events = append(events, prefix+"dead") },
+		}
+	}
+
+	s := &Scheduler{Hooks: record("s.")}
+	run := func(exec func(t *Task) error, maxRetry int) string {
+		events = nil
+		task := &Task{
+			Name:     "test",
+			MaxRetry: maxRetry,
+			Hooks:    record("t."),
+			UserTask: &UserTask{Task: &m.Task{
+				ID:     "c9f51923-293a-4e3b-a49f-cccd71db4679",
+				Status: m.TaskStatusTodo,
+			}},
+			Steps:     []Step{{Name: "step1", Exec: exec}},
+			scheduler: s,
+		}
+		_ = task.Exec()
+		return strings.Join(events, ",")
+	}
+
+	tests := []struct {
+		exec     func(t *Task) error
+		maxRetry int
+		expected string
+	}{
+		{testStep, 1, "s.start,t.start,s.success:step1,t.success:step1,s.done,t.done"},
+		{testFailingStep, 3, "s.start,t.start,s.failure:step1,t.failure:step1,s.retry,t.retry"},
+		{testFailingStep, 1, "s.start,t.start,s.failure:step1,t.failure:step1,s.dead,t.dead"},
+	}
+	for _, test := range tests {
+		if got := run(test.exec, test.maxRetry); got != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, got)
+		}
+	}
+}
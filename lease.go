@@ -0,0 +1,78 @@
+package tasker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kr/pretty"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// DefaultLeaseDuration is the lease of the claimed tasks when
+// Scheduler.LeaseDuration is 0
+const DefaultLeaseDuration = 5 * time.Minute
+
+func (s *Scheduler) leaseDuration() time.Duration {
+	if s.LeaseDuration <= 0 {
+		return DefaultLeaseDuration
+	}
+	return s.LeaseDuration
+}
+
+// leases are the ids of the tasks whose lease must be renewed
+type leases struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func newLeases(tasks m.TaskSlice) *leases {
+	l := &leases{ids: make(map[string]bool, len(tasks))}
+	for _, task := range tasks {
+		l.ids[task.ID] = true
+	}
+	return l
+}
+
+// release stops renewing the lease of a task
+func (l *leases) release(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.ids, id)
+}
+
+func (l *leases) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := make([]string, 0, len(l.ids))
+	for id := range l.ids {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// renewLeases extends the lease of the running tasks three times per lease
+// duration until the returned function is called
+func (s *Scheduler) renewLeases(running *leases) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(s.leaseDuration() / 3)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ids := running.list()
+				if len(ids) == 0 {
+					continue
+				}
+				err := s.store().ExtendLease(s.context(), time.Now().Add(s.leaseDuration()), ids...)
+				if err != nil {
+					pretty.Println(err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
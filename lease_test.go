@@ -0,0 +1,257 @@
+package tasker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestLeaseRenewal(t *testing.T) {
+	useMemoryStore(t)
+
+	other := &Scheduler{Tasks: []Task{{Name: "slow", Steps: []Step{{Name: "step1", Exec: testStep}}}}}
+	reclaimed := -1
+	s := &Scheduler{
+		Tasks: []Task{{Name: "slow", Steps: []Step{{Name: "step1", Exec: func(t *Task) error {
+			time.Sleep(150 * time.Millisecond)
+			reclaimed, _ = other.ExecOnce()
+			return nil
+		}}}}},
+		LeaseDuration: 60 * time.Millisecond,
+	}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "slow", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != 0 {
+		t.Errorf("Expected the running task to keep its lease, claimed %d tasks", reclaimed)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone || task.Retry != 0 {
+		t.Errorf("Expected the task to be done once, got %+v", task)
+	}
+}
+
+func TestTaskSavedWhenDone(t *testing.T) {
+	useMemoryStore(t)
+
+	var fast *m.Task
+	var fastStatus string
+	s := &Scheduler{
+		Tasks: []Task{
+			{Name: "fast", Steps: []Step{{Name: "step1", Exec: testStep}}},
+			{Name: "slow", Steps: []Step{{Name: "step1", Exec: func(t *Task) error {
+				// Wait for the other worker to save its task
+				for i := 0; i < 100; i++ {
+					task, err := GetTask(ctx, fast.ID)
+					if err == nil && task.Status == m.TaskStatusDone {
+						fastStatus = task.Status
+						break
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+				return nil
+			}}}},
+		},
+		Workers: 2,
+	}
+
+	var err error
+	fast, err = s.Enqueue(ctx, EnqueueParams{Name: "fast", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Enqueue(ctx, EnqueueParams{Name: "slow", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 tasks, got %d", n)
+	}
+	if fastStatus != m.TaskStatusDone {
+		t.Errorf("Expected the fast task to be saved while the slow one runs")
+	}
+}
+
+// countingStore counts the updates of the tasks
+type countingStore struct {
+	*MemoryStore
+	updates int32
+}
+
+func (s *countingStore) UpdateClaimed(ctx context.Context, columns []string, tasks ...ClaimedTask) ([]string, error) {
+	atomic.AddInt32(&s.updates, 1)
+	return s.MemoryStore.UpdateClaimed(ctx, columns, tasks...)
+}
+
+func TestTasksSavedByBatch(t *testing.T) {
+	store := &countingStore{MemoryStore: useMemoryStore(t)}
+	s := &Scheduler{
+		Store:          store,
+		Tasks:          []Task{{Name: "short", Steps: []Step{{Name: "step1", Exec: testStep}}}},
+		Workers:        4,
+		ClaimBatchSize: 20,
+	}
+	for i := 0; i < 20; i++ {
+		_, err := s.Enqueue(ctx, EnqueueParams{Name: "short", TodoDate: time.Now().Add(-time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Errorf("Expected 20 tasks, got %d", n)
+	}
+	if store.updates != 1 {
+		t.Errorf("Expected the short tasks to be saved by a single update, got %d", store.updates)
+	}
+	tasks, err := ListTasks(ctx, TaskFilter{Status: m.TaskStatusDone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 20 {
+		t.Errorf("Expected 20 done tasks, got %d", len(tasks))
+	}
+}
+
+func TestCrashedSchedulerTasks(t *testing.T) {
+	useMemoryStore(t)
+
+	var buffers []string
+	s := &Scheduler{Tasks: []Task{{
+		Name:     "import",
+		MaxRetry: 3,
+		Steps: []Step{{Name: "step1", Exec: func(t *Task) error {
+			buffers = append(buffers, string(t.UserTask.UserBuffer.JSON))
+			return nil
+		}}},
+	}}}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "import", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A scheduler claims the task, checkpoints and crashes
+	claimed, err := s.store().Claim(ctx, []string{"import"}, defaultQueues, time.Now().Add(-time.Millisecond), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed[0].UserBuffer = null.JSONFrom([]byte(`{"done":4}`))
+	err = s.store().Update(ctx, []string{m.TaskColumns.UserBuffer}, claimed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buffers) != 1 || buffers[0] != `{"done":4}` {
+		t.Errorf("Expected the task to resume from its checkpoint, got %v", buffers)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone || task.Retry != 1 {
+		t.Errorf("Expected the task to be done after a retry, got %+v", task)
+	}
+}
+
+func TestLostLeaseMaxRetry(t *testing.T) {
+	useMemoryStore(t)
+
+	ran := false
+	s := &Scheduler{Tasks: []Task{{
+		Name:     "import",
+		MaxRetry: 2,
+		Steps: []Step{{Name: "step1", Exec: func(t *Task) error {
+			ran = true
+			return nil
+		}}},
+	}}}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "import", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two schedulers claim the task and crash
+	for i := 0; i < 2; i++ {
+		_, err = s.store().Claim(ctx, []string{"import"}, defaultQueues, time.Now().Add(-time.Millisecond), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Errorf("Expected the task not to run once it reached its max retry")
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusError || task.Retry != 2 {
+		t.Errorf("Expected the task to fail after losing its lease twice, got %+v", task)
+	}
+	attempts, err := GetAttempts(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].Error.String != ErrLeaseExpired.Error() {
+		t.Errorf("Expected an attempt per lost lease, got %+v", attempts)
+	}
+}
+
+func TestStaleWorkerSave(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{Tasks: []Task{{Name: "slow", Steps: []Step{{Name: "step1", Exec: func(t *Task) error {
+		// Another scheduler claims the task again while it runs
+		reclaimed := *t.UserTask.Task
+		reclaimed.Retry++
+		return t.UserTask.store().Update(ctx, []string{m.TaskColumns.Retry}, &reclaimed)
+	}}}}}}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "slow", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDoing || task.Retry != 1 {
+		t.Errorf("Expected the stale worker to leave the task to the other scheduler, got %+v", task)
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kr/pretty"
+	"github.com/volatiletech/null"
 
 	// Import pq globally
 	_ "github.com/lib/pq"
-	"github.com/volatiletech/sqlboiler/boil"
-	"github.com/volatiletech/sqlboiler/queries/qm"
+	"go.opentelemetry.io/otel/trace"
 
 	m "github.com/wesraph/tasker/models"
 )
@@ -26,6 +28,16 @@ var (
 	ErrReachedMaxRetry     = fmt.Errorf("reached max retry for task")
 	ErrReachedEndOfTask    = fmt.Errorf("reached end of task")
 	ErrNilUserTask         = fmt.Errorf("user task is nil")
+	ErrTaskNotFound        = fmt.Errorf("task not found")
+	ErrInvalidStatus       = fmt.Errorf("invalid task status for this operation")
+	ErrTaskCancelled       = fmt.Errorf("task was cancelled")
+	ErrDuplicateTaskName   = fmt.Errorf("duplicate task name")
+	ErrDuplicateStepName   = fmt.Errorf("duplicate step name")
+	ErrInvalidRateLimit    = fmt.Errorf("rate limit needs positive tokens and interval")
+	ErrInvalidConcurrency  = fmt.Errorf("concurrency limit max cannot be negative")
+	ErrMissingConcurrency  = fmt.Errorf("missing concurrency limit key")
+	ErrTaskNotRegistered   = fmt.Errorf("task not registered by a scheduler")
+	ErrLeaseExpired        = fmt.Errorf("lease expired, the task was claimed again")
 )
 
 var ctx context.Context
@@ -35,6 +47,9 @@ var dbh *sql.DB
 type Step struct {
 	Name string
 	Exec func(t *Task) error
+	// Queue routes the step to the schedulers serving it, the step runs on
+	// any scheduler when empty
+	Queue string
 }
 
 // Task is a group of steps
@@ -43,6 +58,32 @@ type Task struct {
 	Steps    []Step
 	UserTask *UserTask
 	MaxRetry int
+	// RateLimit defers the claim of the tasks when set
+	RateLimit *RateLimit
+	// Concurrency limits the tasks running at once with the same arguments
+	Concurrency *ConcurrencyLimit
+	// StepPerClaim runs a single step per claim instead of all the remaining
+	// steps, the task goes back to the queue between its steps so that long
+	// workflows don't hold a worker. The OnStart hooks run for every step.
+	StepPerClaim bool
+	// Version is stamped on the tasks enqueued by the scheduler, several
+	// versions of a task can be registered and each task runs on its own.
+	// The tasks enqueued without a scheduler have no version, they run on
+	// the latest one.
+	Version int
+	// Renames maps the steps of the previous versions to the steps of this
+	// one, a task whose version is not registered anymore is moved to the
+	// latest version with its actual step renamed
+	Renames map[string]string
+
+	// TaskMiddlewares wrap the execution of all the steps, StepMiddlewares
+	// wrap each step. They run inside the ones of the scheduler.
+	TaskMiddlewares []StepMiddleware
+	StepMiddlewares []StepMiddleware
+	Hooks           Hooks
+
+	ctx       context.Context
+	scheduler *Scheduler
 }
 
 // UserTask is a user task
@@ -50,116 +91,560 @@ type UserTask struct {
 	Buffer interface{}
 	Args   interface{}
 	*m.Task
+
+	taskStore Store
+	ctx       context.Context
+	// saved is the task as it was last read or written, only the columns
+	// which changed since are written
+	saved           *m.Task
+	progressSavedAt time.Time
 }
 
-// UpdateDB update the task in db
-func (u UserTask) UpdateDB() error {
-	var err error
+// UpdateDB update the task in db, only the columns which changed since the
+// task was claimed or last updated are written
+func (u *UserTask) UpdateDB() error {
+	columns, err := u.changedColumns()
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	err = u.write(u.context(), columns)
+	if err != nil {
+		return err
+	}
+	u.saved = copyTask(u.Task)
+	return nil
+}
+
+// write saves columns of the task, a task claimed by a scheduler is only
+// written while the scheduler holds its lease
+func (u *UserTask) write(ctx context.Context, columns []string) error {
+	if u.saved == nil || u.saved.Status != m.TaskStatusDoing {
+		return u.store().Update(ctx, columns, u.Task)
+	}
+	lost, err := u.store().UpdateClaimed(ctx, columns, ClaimedTask{Task: u.Task, Retry: u.saved.Retry})
+	if err != nil {
+		return err
+	}
+	if len(lost) > 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// Checkpoint saves the buffer while a step is running, a step retried after
+// a failure or a crash of the scheduler can resume from the saved buffer
+// instead of starting over. Every call writes to the store, long steps
+// should checkpoint every few items rather than after each one.
+func (t *Task) Checkpoint() error {
+	if t.UserTask == nil {
+		return ErrNilUserTask
+	}
+	return t.UserTask.UpdateDB()
+}
+
+// changedColumns marshals the buffer and returns the columns to write
+func (u *UserTask) changedColumns() ([]string, error) {
 	if u.Buffer != nil {
-		err = u.UserBuffer.Marshal(u.Buffer)
+		err := u.UserBuffer.Marshal(u.Buffer)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	_, err = u.Update(ctx, dbh, boil.Infer())
-	return err
+
+	if u.saved == nil {
+		return taskColumns, nil
+	}
+	return changedColumns(u.saved, u.Task), nil
 }
 
 // Scheduler is a group of tasks
 type Scheduler struct {
+	// Tasks are registered when the scheduler starts, see Register
 	Tasks []Task
+
+	// Store holds the queue, the one set by Init or InitStore is used when nil
+	Store Store
+	// Context is the context of the store queries and of the steps,
+	// context.Background() when nil
+	Context context.Context
+	// ClaimBatchSize is the maximum number of tasks claimed at once, 10 when
+	// 0. Bigger batches mean less queries for short tasks.
+	ClaimBatchSize int
+	// Workers is the number of claimed tasks executed concurrently, 1 when
+	// 0. The hooks and middlewares must be safe for concurrent use when it
+	// is greater.
+	Workers int
+	// ProgressInterval is the minimum delay between two saves of the
+	// progress reported by a step, DefaultProgressInterval when 0
+	ProgressInterval time.Duration
+	// LeaseDuration is how long a claimed task is reserved to the scheduler,
+	// DefaultLeaseDuration when 0. The lease of the running tasks is renewed
+	// while they run, the tasks of a crashed scheduler are claimed again
+	// when it expires.
+	LeaseDuration time.Duration
+	// Queues are the step queues served besides the default one, the tasks
+	// reaching a step of another queue are left for the schedulers serving it
+	Queues []string
+	// NoDefaultQueue leaves the steps of the default queue to other
+	// schedulers. The tasks enqueued without a scheduler, by the package
+	// Enqueue, the API or the CLI, wait in the default queue until a
+	// scheduler serving it routes them to the queue of their first step.
+	NoDefaultQueue bool
+
+	// Retention policies are applied every RetentionInterval,
+	// DefaultRetentionInterval when 0
+	Retention         []RetentionPolicy
+	RetentionInterval time.Duration
+
+	// Middlewares and hooks applied to every task
+	TaskMiddlewares []StepMiddleware
+	StepMiddlewares []StepMiddleware
+	Hooks           Hooks
+
+	queueMetricsAt time.Time
+	retentionAt    time.Time
+	busy           int32
+	tokens         rateWindows
+	registry       map[string]Task
+	versions       map[string][]Task
+	names          []string
 }
 
 // Init the database connection and context
 func Init(db *sql.DB) {
 	dbh = db
 	ctx = context.Background()
+	store = NewPostgresStore(db)
 }
 
 // Exec execute all tasks in the scheduler
 func (s *Scheduler) Exec() error {
 	fmt.Println("Launching scheduler")
+	err := s.reportOrphanedSteps()
+	if err != nil {
+		return err
+	}
+	metrics.WorkersBusy(0, s.workers())
 	for {
-		//Get all tasks waiting in db
-		fmt.Println("Checking new tasks")
-		todoTasks, err := m.Tasks(qm.Where("todo_date<?", time.Now()), qm.And("status=?", m.TaskStatusTodo)).All(ctx, dbh)
+		n, err := s.ExecOnce()
 		if err != nil {
 			return err
 		}
 
-		for _, todoTaskDB := range todoTasks {
-			//Find corresponding task
-			todoTask := &UserTask{
-				Task: todoTaskDB,
+		// Keep going while the queue is full
+		if n < s.claimBatchSize() {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (s *Scheduler) claimBatchSize() int {
+	if s.ClaimBatchSize <= 0 {
+		return 10
+	}
+	return s.ClaimBatchSize
+}
+
+// queues returns the queues claimed by the scheduler, the default one first
+// unless NoDefaultQueue is set
+func (s *Scheduler) queues() []string {
+	if s.NoDefaultQueue {
+		return s.Queues
+	}
+	return append([]string{""}, s.Queues...)
+}
+
+// serves is true when the steps of queue run on the scheduler
+func (s *Scheduler) serves(queue string) bool {
+	if queue == "" {
+		return !s.NoDefaultQueue
+	}
+	for _, q := range s.Queues {
+		if q == queue {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scheduler) context() context.Context {
+	if s.Context == nil {
+		return context.Background()
+	}
+	return s.Context
+}
+
+func (s *Scheduler) workers() int {
+	if s.Workers <= 0 {
+		return 1
+	}
+	return s.Workers
+}
+
+// ExecOnce claims a batch of due tasks and executes them with the workers,
+// the final state of the tasks finishing within saveInterval of each other
+// is written at once. It returns the number of executed tasks.
+func (s *Scheduler) ExecOnce() (int, error) {
+	defs, names, err := s.definitions()
+	if err != nil {
+		return 0, err
+	}
+	s.refreshQueueMetrics()
+	s.applyRetention()
+
+	//Get all tasks waiting in db, they are marked as running while they execute
+	fmt.Println("Checking new tasks")
+	pollStart := time.Now()
+	todoTasks, err := s.claim(names, defs)
+	if err != nil {
+		return 0, err
+	}
+	metrics.PollDuration(time.Since(pollStart))
+
+	running := newLeases(todoTasks)
+	stopRenew := s.renewLeases(running)
+	defer stopRenew()
+
+	finished := make(chan *UserTask, len(todoTasks))
+	saved := make(chan error, 1)
+	go func() {
+		saved <- s.saveFinished(finished, running)
+	}()
+
+	workers := make(chan struct{}, s.workers())
+	var wg sync.WaitGroup
+	for _, todoTaskDB := range todoTasks {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(todoTaskDB *m.Task) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			finished <- s.execTask(todoTaskDB)
+		}(todoTaskDB)
+	}
+	wg.Wait()
+	close(finished)
+
+	err = <-saved
+	if err != nil {
+		return 0, err
+	}
+	return len(todoTasks), nil
+}
+
+// saveInterval is how long a finished task waits for others to be saved with
+const saveInterval = 20 * time.Millisecond
+
+// saveFinished saves the tasks received from finished by batches, a batch is
+// written saveInterval after its first task so that a slow task does not hold
+// back the outcome of the others. It returns the first error.
+func (s *Scheduler) saveFinished(finished <-chan *UserTask, running *leases) error {
+	var batch []*UserTask
+	var deadline <-chan time.Time
+	var saveErr error
+	flush := func() {
+		err := s.saveTasks(batch)
+		if err != nil && saveErr == nil {
+			saveErr = err
+		}
+		for _, u := range batch {
+			running.release(u.ID)
+		}
+		batch = nil
+		deadline = nil
+	}
+
+	for {
+		select {
+		case u, ok := <-finished:
+			if !ok {
+				flush()
+				return saveErr
 			}
-			var fnt Task
-			for _, findNewTask := range s.Tasks {
-				if findNewTask.Name == todoTask.Name {
-					fnt = findNewTask
-				}
+			if batch == nil {
+				deadline = time.After(saveInterval)
 			}
+			batch = append(batch, u)
+		case <-deadline:
+			flush()
+		}
+	}
+}
 
-			if fnt.Name == "" {
-				//TODO:Log error and commit status error
-				fmt.Println("tasker: task type not found")
-				continue
-			}
+// claim claims up to the claim batch size of due tasks, the tasks with a
+// rate or concurrency limit are claimed first within their limits
+func (s *Scheduler) claim(names []string, defs map[string]Task) (m.TaskSlice, error) {
+	limit := s.claimBatchSize()
+	lease := time.Now().Add(s.leaseDuration())
+	var claimed m.TaskSlice
+	var unlimited []string
+	for _, name := range names {
+		def := defs[name]
+		if def.RateLimit == nil && def.Concurrency == nil {
+			unlimited = append(unlimited, name)
+			continue
+		}
+		if len(claimed) >= limit {
+			continue
+		}
 
-			execTask := fnt
-			execTask.UserTask = todoTask
+		tasks, err := s.claimLimited(def, lease, limit-len(claimed))
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, tasks...)
+	}
 
-			err = execTask.Exec()
-			if err != nil && err == ErrReachedMaxRetry {
-				//TODO:Log error and commit status error
-				fmt.Println("Task reached max retry count, setting state error")
-				execTask.UserTask.Status = m.TaskStatusError
-			} else if err != nil {
-				pretty.Println(err)
-			}
+	if len(unlimited) == 0 || len(claimed) >= limit {
+		return claimed, nil
+	}
+	claimStart := time.Now()
+	tasks, err := s.store().Claim(s.context(), unlimited, s.queues(), lease, limit-len(claimed))
+	metrics.ClaimDuration(time.Since(claimStart))
+	return append(claimed, tasks...), err
+}
 
-			fmt.Println("Update task in DB")
-			err := execTask.UserTask.UpdateDB()
-			if err != nil {
-				return err
+// claimLimited claims the tasks of a definition with a rate or concurrency
+// limit
+func (s *Scheduler) claimLimited(def Task, lease time.Time, limit int) (m.TaskSlice, error) {
+	if def.RateLimit != nil {
+		tokens, err := s.useTokens(def.Name, def.RateLimit, limit)
+		if err != nil {
+			return nil, err
+		}
+		if tokens == 0 {
+			fmt.Printf("Rate limit of %s reached, deferring\n", def.Name)
+			return nil, nil
+		}
+		limit = tokens
+	}
+
+	var tasks m.TaskSlice
+	var err error
+	claimStart := time.Now()
+	if def.Concurrency != nil {
+		tasks, err = s.store().ClaimByKey(s.context(), def.Name, s.queues(), def.Concurrency.Key, def.Concurrency.max(), lease, limit)
+	} else {
+		tasks, err = s.store().Claim(s.context(), []string{def.Name}, s.queues(), lease, limit)
+	}
+	metrics.ClaimDuration(time.Since(claimStart))
+
+	if unused := limit - len(tasks); def.RateLimit != nil && unused > 0 {
+		// The claimed tasks must run anyway, failing to give back the
+		// tokens only wastes them until the next interval
+		_, returnErr := s.useTokens(def.Name, def.RateLimit, -unused)
+		if returnErr != nil {
+			pretty.Println(returnErr)
+		}
+	}
+	return tasks, err
+}
+
+// execTask executes a claimed task and sets its final status, the task is
+// not saved
+func (s *Scheduler) execTask(todoTaskDB *m.Task) *UserTask {
+	userTask := &UserTask{
+		Task:      todoTaskDB,
+		taskStore: s.store(),
+		ctx:       s.context(),
+		saved:     copyTask(todoTaskDB),
+	}
+	def, ok := s.definition(todoTaskDB)
+	if !ok {
+		fmt.Printf("Task %s is at version %d, newer than the registered ones, leaving it\n", todoTaskDB.ID, todoTaskDB.Version)
+		userTask.Status = m.TaskStatusTodo
+		userTask.TodoDate = time.Now().Add(newerVersionDelay)
+		return userTask
+	}
+
+	execTask := def
+	execTask.UserTask = userTask
+	execTask.scheduler = s
+	metrics.TaskStarted(execTask.Name)
+
+	metrics.WorkersBusy(int(atomic.AddInt32(&s.busy, 1)), s.workers())
+	err := execTask.Exec()
+	metrics.WorkersBusy(int(atomic.AddInt32(&s.busy, -1)), s.workers())
+	if err != nil && err == ErrReachedMaxRetry {
+		//TODO:Log error and commit status error
+		fmt.Println("Task reached max retry count, setting state error")
+		execTask.UserTask.Status = m.TaskStatusError
+	} else if err != nil {
+		pretty.Println(err)
+	}
+
+	switch execTask.UserTask.Status {
+	case m.TaskStatusDoing:
+		execTask.UserTask.Status = m.TaskStatusTodo
+	case m.TaskStatusDone, m.TaskStatusError:
+		execTask.UserTask.FinishedAt = null.TimeFrom(time.Now())
+	}
+	return execTask.UserTask
+}
+
+// saveTasks writes the changed columns of the tasks with a single update,
+// the tasks claimed again after their lease expired are left untouched
+func (s *Scheduler) saveTasks(tasks []*UserTask) error {
+	var columns []string
+	seen := map[string]bool{}
+	rows := make([]ClaimedTask, 0, len(tasks))
+	for _, u := range tasks {
+		changed, err := u.changedColumns()
+		if err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		for _, c := range changed {
+			if !seen[c] {
+				seen[c] = true
+				columns = append(columns, c)
 			}
-			fmt.Println("Ok")
 		}
+		rows = append(rows, ClaimedTask{Task: u.Task, Retry: u.saved.Retry})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	lost, err := s.store().UpdateClaimed(s.context(), columns, rows...)
+	if err != nil {
+		return err
+	}
+	for _, id := range lost {
+		fmt.Printf("Task %s lost its lease, leaving it to the scheduler which claimed it again\n", id)
+	}
+	for _, u := range tasks {
+		u.saved = copyTask(u.Task)
+	}
+	return nil
+}
 
-		time.Sleep(time.Second)
+// Context returns the context of the running step, it carries the step span
+func (t *Task) Context() context.Context {
+	if t.ctx != nil {
+		return t.ctx
+	}
+	if t.scheduler != nil {
+		return t.scheduler.context()
+	}
+	if ctx != nil {
+		return ctx
 	}
+	return context.Background()
 }
 
 // Exec execute at task
-func (t *Task) Exec() error {
-	err := t.initValidate()
+func (t *Task) Exec() (err error) {
+	err = t.initValidate()
 	if err != nil {
 		return err
 	}
+	if t.UserTask.Retry > 0 && t.UserTask.Retry >= t.MaxRetry {
+		// Claimed again after its lease expired as many times as allowed,
+		// like a task crashing the schedulers executing it
+		t.UserTask.Status = m.TaskStatusError
+		metrics.TaskFailed(t.Name)
+		t.onDead(ErrLeaseExpired)
+		return ErrReachedMaxRetry
+	}
+
+	step, err := t.getActualStep()
+	if err != nil {
+		return t.orphaned()
+	}
+	if !t.serves(step) {
+		// Claimed from the default queue before reaching a step of another
+		// one, like a task enqueued on such a step
+		t.route(step)
+		return nil
+	}
 
+	taskCtx, span := tracer().Start(extractTraceContext(t.Context(), t.UserTask.Task), t.Name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(taskAttributes(t.UserTask.Task)...))
+	t.ctx = taskCtx
+	defer func() {
+		span.SetAttributes(AttrStep.String(t.UserTask.ActualStep), AttrRetry.Int(t.UserTask.Retry))
+		endSpan(span, err)
+		t.ctx = nil
+	}()
+
+	t.onStart()
+	finished := false
+	var stepsErr error
+	err = safeExec(t.taskHandler(func(t *Task) error {
+		stepsErr = t.execSteps()
+		finished = true
+		return stepsErr
+	}), t)
+	if perr, ok := err.(*PanicError); ok {
+		if finished {
+			// A task middleware panicked after the steps, which already set
+			// the outcome of the execution
+			fmt.Printf("Task middleware of %s panicked after the steps\n", t.Name)
+			pretty.Println(perr)
+			return stepsErr
+		}
+		// A task middleware panicked outside of any step
+		t.recordAttempt(t.UserTask.ActualStep, time.Now(), perr)
+		return t.stepFailed(t.UserTask.ActualStep, perr)
+	}
+	return err
+}
+
+// execSteps runs the steps from the actual one until the end of the task or
+// the first failure, or only the actual one with StepPerClaim
+func (t *Task) execSteps() error {
 	actStep, err := t.getActualStep()
+	if err != nil {
+		return t.orphaned()
+	}
 	for {
-		err = actStep.Exec(t)
+		taskCtx := t.Context()
+		var stepSpan trace.Span
+		t.ctx, stepSpan = tracer().Start(taskCtx, t.Name+"."+actStep.Name,
+			trace.WithAttributes(taskAttributes(t.UserTask.Task)...))
+
+		startedAt := time.Now()
+		err = safeExec(t.stepHandler(actStep), t)
+		metrics.StepDuration(t.Name, actStep.Name, time.Since(startedAt))
+		t.recordAttempt(actStep.Name, startedAt, err)
+		endSpan(stepSpan, err)
+		t.ctx = taskCtx
 		if err != nil {
-			fmt.Printf("Step %s failed : %s\n", actStep.Name, err.Error())
-
-			if t.UserTask.Retry+1 >= t.MaxRetry {
-				return ErrReachedMaxRetry
-			}
-
-			t.UserTask.Retry++
-			return nil
+			trace.SpanFromContext(taskCtx).RecordError(err)
+			return t.stepFailed(actStep.Name, err)
 		}
+		t.onStepSuccess(actStep.Name)
 
 		actStep, err = t.getNextStep()
 
 		if err == ErrReachedEndOfTask {
 			t.UserTask.Status = m.TaskStatusDone
+			metrics.TaskSucceeded(t.Name)
+			t.onDone()
 			return nil
+		} else if err == ErrStepNotFound {
+			return t.orphaned()
 		} else if err != nil {
 			return err
 		}
 
 		t.UserTask.ActualStep = actStep.Name
+		t.UserTask.Queue = actStep.Queue
+		t.UserTask.Progress = null.JSON{}
+
+		if t.StepPerClaim || !t.serves(actStep) {
+			// Back to the queue behind the tasks already due, the next step
+			// runs on a later claim
+			t.route(actStep)
+			return nil
+		}
 
 		err = t.UserTask.UpdateDB()
 		if err != nil {
@@ -169,6 +654,50 @@ func (t *Task) Exec() error {
 
 }
 
+// serves is true when the step can run on the scheduler executing the task,
+// a task executed without scheduler runs all its steps
+func (t *Task) serves(step *Step) bool {
+	return t.scheduler == nil || t.scheduler.serves(step.Queue)
+}
+
+// route puts the task back in the queue of step, it is claimed again by a
+// scheduler serving it
+func (t *Task) route(step *Step) {
+	t.UserTask.ActualStep = step.Name
+	t.UserTask.Queue = step.Queue
+	t.UserTask.Status = m.TaskStatusTodo
+	t.UserTask.TodoDate = time.Now()
+}
+
+// orphaned ends in error a task whose actual step is not a step of its
+// definition, without retry as no attempt could succeed. The reason is
+// recorded as an attempt of the missing step.
+func (t *Task) orphaned() error {
+	err := &DefinitionError{Task: t.Name, Version: t.Version, Step: t.UserTask.ActualStep, Index: -1, Err: ErrStepNotFound}
+	t.recordAttempt(t.UserTask.ActualStep, time.Now(), err)
+	t.UserTask.Status = m.TaskStatusError
+	metrics.TaskFailed(t.Name)
+	t.onDead(err)
+	return err
+}
+
+// stepFailed counts a failure against the max retry of the task
+func (t *Task) stepFailed(step string, err error) error {
+	fmt.Printf("Step %s failed : %s\n", step, err.Error())
+	t.onStepFailure(step, err)
+
+	if t.UserTask.Retry+1 >= t.MaxRetry {
+		metrics.TaskFailed(t.Name)
+		t.onDead(err)
+		return ErrReachedMaxRetry
+	}
+
+	t.UserTask.Retry++
+	metrics.TaskRetried(t.Name)
+	t.onRetry(err)
+	return nil
+}
+
 func (t *Task) initValidate() error {
 	if len(t.Steps) == 0 {
 		return ErrMissingSteps
@@ -180,7 +709,7 @@ func (t *Task) initValidate() error {
 
 	for _, s := range t.Steps {
 		if s.Name == "" {
-			return ErrMissingTaskName
+			return ErrMissingStepName
 		}
 		if s.Exec == nil {
 			return ErrMissingExecFunction
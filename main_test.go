@@ -354,3 +354,245 @@ func TestScheduler(t *testing.T) {
 		t.Errorf("Failing using scheduler" + err.Error())
 	}
 }
+
+func TestCheckpoint(t *testing.T) {
+	useMemoryStore(t)
+
+	type progress struct {
+		Done int `json:"done"`
+	}
+	failed := false
+	var processed []int
+	step := func(task *Task) error {
+		p := &progress{}
+		if task.UserTask.UserBuffer.Valid {
+			err := task.UserTask.UserBuffer.Unmarshal(p)
+			if err != nil {
+				return err
+			}
+		}
+		task.UserTask.Buffer = p
+
+		for ; p.Done < 10; p.Done++ {
+			if p.Done == 5 && !failed {
+				failed = true
+				saved, err := GetTask(ctx, task.UserTask.ID)
+				if err != nil {
+					return err
+				}
+				if string(saved.UserBuffer.JSON) != `{"done":4}` {
+					t.Errorf("Expected the checkpoint to be saved, got %s", saved.UserBuffer.JSON)
+				}
+				return fmt.Errorf("node unavailable")
+			}
+			processed = append(processed, p.Done)
+			if p.Done%2 == 0 {
+				err := task.Checkpoint()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:     "test",
+			MaxRetry: 2,
+			Steps:    []Step{{Name: "items", Exec: step}},
+		}},
+	}
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "test", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = s.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone {
+		t.Errorf("Expected the task to be done, got %s", task.Status)
+	}
+	if len(processed) != 10 {
+		t.Errorf("Expected every item to be processed once, got %v", processed)
+	}
+}
+
+func TestStepPerClaim(t *testing.T) {
+	useMemoryStore(t)
+
+	var order []string
+	step := func(name string) Step {
+		return Step{Name: name, Exec: func(t *Task) error {
+			order = append(order, t.Name+"."+name)
+			return nil
+		}}
+	}
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:         "long",
+			Steps:        []Step{step("step1"), step("step2"), step("step3")},
+			StepPerClaim: true,
+		}, {
+			Name:  "short",
+			Steps: []Step{step("step1")},
+		}},
+		ClaimBatchSize: 1,
+	}
+
+	long, err := s.Enqueue(ctx, EnqueueParams{Name: "long", TodoDate: time.Now().Add(-2 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Enqueue(ctx, EnqueueParams{Name: "short", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err = s.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			task, err := GetTask(ctx, long.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if task.Status != m.TaskStatusTodo || task.ActualStep != "step2" {
+				t.Errorf("Expected the task to wait for its second step, got %+v", task)
+			}
+		}
+	}
+
+	expected := []string{"long.step1", "short.step1", "long.step2", "long.step3"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Errorf("Expected the steps to run in order %v, got %v", expected, order)
+	}
+	task, err := GetTask(ctx, long.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone {
+		t.Errorf("Expected the task to be done, got %s", task.Status)
+	}
+}
+
+func TestStepQueues(t *testing.T) {
+	useMemoryStore(t)
+
+	var order []string
+	step := func(name, queue string) Step {
+		return Step{Name: name, Queue: queue, Exec: func(t *Task) error {
+			order = append(order, t.scheduler.Queues[0]+":"+name)
+			return nil
+		}}
+	}
+	tasks := []Task{{
+		Name:  "render",
+		Steps: []Step{step("prepare", ""), step("render", "gpu"), step("upload", "")},
+	}}
+	cpu := &Scheduler{Tasks: tasks, Queues: []string{"cpu"}}
+	gpu := &Scheduler{Tasks: tasks, Queues: []string{"gpu"}}
+
+	task, err := cpu.Enqueue(ctx, EnqueueParams{Name: "render", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = cpu.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusTodo || task.ActualStep != "render" || task.Queue != "gpu" {
+		t.Errorf("Expected the task to wait in the gpu queue, got %+v", task)
+	}
+
+	// The steps of the default queue run on any scheduler
+	_, err = gpu.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"cpu:prepare", "gpu:render", "gpu:upload"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Errorf("Expected the steps to run in order %v, got %v", expected, order)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone {
+		t.Errorf("Expected the task to be done, got %s", task.Status)
+	}
+}
+
+func TestNoDefaultQueue(t *testing.T) {
+	useMemoryStore(t)
+
+	var order []string
+	step := func(name, queue string) Step {
+		return Step{Name: name, Queue: queue, Exec: func(t *Task) error {
+			order = append(order, t.scheduler.Queues[0]+":"+name)
+			return nil
+		}}
+	}
+	tasks := []Task{
+		{Name: "render", Steps: []Step{step("render", "gpu"), step("upload", "")}},
+		{Name: "report", Steps: []Step{step("report", "")}},
+	}
+	gpu := &Scheduler{Tasks: tasks, Queues: []string{"gpu"}, NoDefaultQueue: true}
+
+	// The task is enqueued in the queue of its first step
+	render, err := gpu.Enqueue(ctx, EnqueueParams{Name: "render", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if render.Queue != "gpu" {
+		t.Errorf("Expected the task in the gpu queue, got %q", render.Queue)
+	}
+	report, err := gpu.Enqueue(ctx, EnqueueParams{Name: "report", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = gpu.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(order) != "[gpu:render]" {
+		t.Errorf("Expected only the gpu step to run, got %v", order)
+	}
+	render, err = GetTask(ctx, render.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if render.Status != m.TaskStatusTodo || render.ActualStep != "upload" || render.Queue != "" {
+		t.Errorf("Expected the task to wait in the default queue, got %+v", render)
+	}
+	report, err = GetTask(ctx, report.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != m.TaskStatusTodo {
+		t.Errorf("Expected the task of the default queue to be left, got %s", report.Status)
+	}
+}
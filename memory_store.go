@@ -0,0 +1,525 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// MemoryStore is a Store keeping the tasks in memory, it is meant for tests
+// and for processes which don't need the queue to survive a restart
+type MemoryStore struct {
+	mu       sync.Mutex
+	tasks    map[string]*m.Task
+	dedup    map[string]string
+	attempts []*Attempt
+	tokens   rateWindows
+	archive  m.TaskSlice
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tasks: map[string]*m.Task{}, dedup: map[string]string{}}
+}
+
+// Enqueue implements Store
+func (s *MemoryStore) Enqueue(ctx context.Context, tasks ...*m.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, task := range tasks {
+		if key := dedupKey(task); key != "" {
+			if id, ok := s.dedup[key]; ok {
+				*task = *copyTask(s.tasks[id])
+				continue
+			}
+		}
+
+		err := setEnqueueDefaults(task)
+		if err != nil {
+			return err
+		}
+		s.tasks[task.ID] = copyTask(task)
+		if key := dedupKey(task); key != "" {
+			s.dedup[key] = task.ID
+		}
+	}
+	return nil
+}
+
+// Claim implements Store
+func (s *MemoryStore) Claim(ctx context.Context, names, queues []string, lease time.Time, limit int) (m.TaskSlice, error) {
+	wanted := stringSet(names)
+	inQueue := stringSet(queues)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.requeueExpired(now)
+	var due m.TaskSlice
+	for _, task := range s.tasks {
+		if task.Status == m.TaskStatusTodo && task.TodoDate.Before(now) && wanted[task.Name] && inQueue[task.Queue] {
+			due = append(due, task)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].TodoDate.Before(due[j].TodoDate)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make(m.TaskSlice, 0, len(due))
+	for _, task := range due {
+		task.Status = m.TaskStatusDoing
+		task.LeaseUntil = null.TimeFrom(lease)
+		claimed = append(claimed, copyTask(task))
+	}
+	return claimed, nil
+}
+
+// requeueExpired puts back to todo the doing tasks whose lease expired and
+// records the lost execution as a failed attempt
+func (s *MemoryStore) requeueExpired(now time.Time) {
+	for _, task := range s.tasks {
+		if task.Status == m.TaskStatusDoing && task.LeaseUntil.Valid && task.LeaseUntil.Time.Before(now) {
+			id, err := uuid.NewV4()
+			if err != nil {
+				continue
+			}
+			s.attempts = append(s.attempts, &Attempt{
+				ID:         id.String(),
+				TaskID:     task.ID,
+				Step:       task.ActualStep,
+				Retry:      task.Retry,
+				StartedAt:  now,
+				FinishedAt: now,
+				Error:      null.StringFrom(ErrLeaseExpired.Error()),
+			})
+			task.Status = m.TaskStatusTodo
+			task.Retry++
+		}
+	}
+}
+
+// ExtendLease implements Store
+func (s *MemoryStore) ExtendLease(ctx context.Context, lease time.Time, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if task, ok := s.tasks[id]; ok && task.Status == m.TaskStatusDoing {
+			task.LeaseUntil = null.TimeFrom(lease)
+		}
+	}
+	return nil
+}
+
+// ClaimByKey implements Store
+func (s *MemoryStore) ClaimByKey(ctx context.Context, name string, queues []string, key string, max int, lease time.Time, limit int) (m.TaskSlice, error) {
+	inQueue := stringSet(queues)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.requeueExpired(now)
+	doing := map[string]int{}
+	var due m.TaskSlice
+	for _, task := range s.tasks {
+		if task.Name != name {
+			continue
+		}
+		if task.Status == m.TaskStatusDoing {
+			if value, ok := argValue(task, key); ok {
+				doing[value]++
+			}
+		}
+		if task.Status == m.TaskStatusTodo && task.TodoDate.Before(now) && inQueue[task.Queue] {
+			due = append(due, task)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].TodoDate.Before(due[j].TodoDate)
+	})
+
+	var claimed m.TaskSlice
+	for _, task := range due {
+		if len(claimed) >= limit {
+			break
+		}
+		if value, ok := argValue(task, key); ok {
+			if doing[value] >= max {
+				continue
+			}
+			doing[value]++
+		}
+		task.Status = m.TaskStatusDoing
+		task.LeaseUntil = null.TimeFrom(lease)
+		claimed = append(claimed, copyTask(task))
+	}
+	return claimed, nil
+}
+
+func stringSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
+
+// Update implements Store
+func (s *MemoryStore) Update(ctx context.Context, columns []string, tasks ...*m.Task) error {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, task := range tasks {
+		saved, ok := s.tasks[task.ID]
+		if !ok {
+			return ErrTaskNotFound
+		}
+
+		updated := copyTask(saved)
+		for _, c := range columns {
+			err := setTaskColumn(updated, copyTask(task), c)
+			if err != nil {
+				return err
+			}
+		}
+		s.tasks[task.ID] = updated
+	}
+	return nil
+}
+
+// UpdateClaimed implements Store
+func (s *MemoryStore) UpdateClaimed(ctx context.Context, columns []string, tasks ...ClaimedTask) ([]string, error) {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lost []string
+	for _, c := range tasks {
+		saved, ok := s.tasks[c.Task.ID]
+		if !ok || saved.Status != m.TaskStatusDoing || saved.Retry != c.Retry {
+			lost = append(lost, c.Task.ID)
+			continue
+		}
+
+		updated := copyTask(saved)
+		for _, column := range columns {
+			err := setTaskColumn(updated, copyTask(c.Task), column)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.tasks[c.Task.ID] = updated
+	}
+	return lost, nil
+}
+
+// UpdateIf implements Store
+func (s *MemoryStore) UpdateIf(ctx context.Context, columns []string, task *m.Task, statuses ...string) error {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved, ok := s.tasks[task.ID]
+	if !ok || !stringSet(statuses)[saved.Status] {
+		return ErrInvalidStatus
+	}
+
+	updated := copyTask(saved)
+	for _, c := range columns {
+		err := setTaskColumn(updated, copyTask(task), c)
+		if err != nil {
+			return err
+		}
+	}
+	s.tasks[task.ID] = updated
+	return nil
+}
+
+// setTaskColumn copies a column of src to dst
+func setTaskColumn(dst, src *m.Task, column string) error {
+	switch column {
+	case m.TaskColumns.TodoDate:
+		dst.TodoDate = src.TodoDate
+	case m.TaskColumns.Name:
+		dst.Name = src.Name
+	case m.TaskColumns.ActualStep:
+		dst.ActualStep = src.ActualStep
+	case m.TaskColumns.Status:
+		dst.Status = src.Status
+	case m.TaskColumns.Retry:
+		dst.Retry = src.Retry
+	case m.TaskColumns.UserBuffer:
+		dst.UserBuffer = src.UserBuffer
+	case m.TaskColumns.UserArgs:
+		dst.UserArgs = src.UserArgs
+	case m.TaskColumns.TraceContext:
+		dst.TraceContext = src.TraceContext
+	case m.TaskColumns.DedupKey:
+		dst.DedupKey = src.DedupKey
+	case m.TaskColumns.Result:
+		dst.Result = src.Result
+	case m.TaskColumns.Progress:
+		dst.Progress = src.Progress
+	case m.TaskColumns.Queue:
+		dst.Queue = src.Queue
+	case m.TaskColumns.Version:
+		dst.Version = src.Version
+	case m.TaskColumns.LeaseUntil:
+		dst.LeaseUntil = src.LeaseUntil
+	case m.TaskColumns.FinishedAt:
+		dst.FinishedAt = src.FinishedAt
+	default:
+		return fmt.Errorf("tasker: column %s cannot be updated", column)
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(ctx context.Context, id string) (*m.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return copyTask(task), nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(ctx context.Context, f TaskFilter) (m.TaskSlice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tasks m.TaskSlice
+	for _, task := range s.tasks {
+		if f.Name != "" && task.Name != f.Name {
+			continue
+		}
+		if f.Status != "" && task.Status != f.Status {
+			continue
+		}
+		if !f.After.IsZero() && task.TodoDate.Before(f.After) {
+			continue
+		}
+		if !f.Before.IsZero() && !task.TodoDate.Before(f.Before) {
+			continue
+		}
+		tasks = append(tasks, copyTask(task))
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+	})
+
+	if f.Offset > 0 {
+		if f.Offset > len(tasks) {
+			f.Offset = len(tasks)
+		}
+		tasks = tasks[f.Offset:]
+	}
+	if f.Limit > 0 && len(tasks) > f.Limit {
+		tasks = tasks[:f.Limit]
+	}
+	return tasks, nil
+}
+
+// Counts implements Store
+func (s *MemoryStore) Counts(ctx context.Context) ([]NameStatusCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type key struct{ name, status string }
+	byKey := map[key]*NameStatusCount{}
+	for _, task := range s.tasks {
+		k := key{task.Name, task.Status}
+		c, ok := byKey[k]
+		if !ok {
+			byKey[k] = &NameStatusCount{Name: task.Name, Status: task.Status, Count: 1, OldestDue: task.TodoDate}
+			continue
+		}
+		c.Count++
+		if task.TodoDate.Before(c.OldestDue) {
+			c.OldestDue = task.TodoDate
+		}
+	}
+
+	counts := make([]NameStatusCount, 0, len(byKey))
+	for _, c := range byKey {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Name != counts[j].Name {
+			return counts[i].Name < counts[j].Name
+		}
+		return counts[i].Status < counts[j].Status
+	})
+	return counts, nil
+}
+
+// PendingSteps implements Store
+func (s *MemoryStore) PendingSteps(ctx context.Context) ([]StepCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type key struct {
+		name    string
+		version int
+		step    string
+	}
+	byKey := map[key]int64{}
+	for _, task := range s.tasks {
+		if task.Status == m.TaskStatusTodo || task.Status == m.TaskStatusDoing {
+			byKey[key{task.Name, task.Version, task.ActualStep}]++
+		}
+	}
+
+	counts := make([]StepCount, 0, len(byKey))
+	for k, n := range byKey {
+		counts = append(counts, StepCount{Name: k.name, Version: k.version, Step: k.step, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Name != counts[j].Name {
+			return counts[i].Name < counts[j].Name
+		}
+		if counts[i].Version != counts[j].Version {
+			return counts[i].Version < counts[j].Version
+		}
+		return counts[i].Step < counts[j].Step
+	})
+	return counts, nil
+}
+
+// AddAttempt implements Store
+func (s *MemoryStore) AddAttempt(ctx context.Context, a *Attempt) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	a.ID = id.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *a
+	s.attempts = append(s.attempts, &c)
+	return nil
+}
+
+// Attempts implements Store
+func (s *MemoryStore) Attempts(ctx context.Context, taskID string) ([]*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attempts []*Attempt
+	for _, a := range s.attempts {
+		if a.TaskID == taskID {
+			c := *a
+			attempts = append(attempts, &c)
+		}
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].StartedAt.Before(attempts[j].StartedAt)
+	})
+	return attempts, nil
+}
+
+// Throughput implements Store
+func (s *MemoryStore) Throughput(ctx context.Context, since time.Time) ([]*ThroughputBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byHour := map[time.Time]*ThroughputBucket{}
+	for _, a := range s.attempts {
+		if a.FinishedAt.Before(since) {
+			continue
+		}
+		hour := a.FinishedAt.Truncate(time.Hour)
+		b, ok := byHour[hour]
+		if !ok {
+			b = &ThroughputBucket{Hour: hour}
+			byHour[hour] = b
+		}
+		if a.Error.Valid {
+			b.Failed++
+		} else {
+			b.Succeeded++
+		}
+	}
+
+	buckets := make([]*ThroughputBucket, 0, len(byHour))
+	for _, b := range byHour {
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Hour.Before(buckets[j].Hour)
+	})
+	return buckets, nil
+}
+
+// UseTokens implements Store
+func (s *MemoryStore) UseTokens(ctx context.Context, name string, window time.Time, max, n int) (int, error) {
+	return s.tokens.use(name, window, max, n), nil
+}
+
+// Purge implements Store
+func (s *MemoryStore) Purge(ctx context.Context, status string, before time.Time, archive bool, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged m.TaskSlice
+	for _, task := range s.tasks {
+		if task.Status == status && task.FinishedAt.Valid && task.FinishedAt.Time.Before(before) {
+			purged = append(purged, task)
+		}
+	}
+	sort.Slice(purged, func(i, j int) bool {
+		return purged[i].FinishedAt.Time.Before(purged[j].FinishedAt.Time)
+	})
+	if len(purged) > limit {
+		purged = purged[:limit]
+	}
+
+	ids := map[string]bool{}
+	for _, task := range purged {
+		ids[task.ID] = true
+		delete(s.tasks, task.ID)
+		if key := dedupKey(task); key != "" {
+			delete(s.dedup, key)
+		}
+		if archive {
+			s.archive = append(s.archive, task)
+		}
+	}
+
+	attempts := s.attempts[:0]
+	for _, a := range s.attempts {
+		if !ids[a.TaskID] {
+			attempts = append(attempts, a)
+		}
+	}
+	s.attempts = attempts
+	return len(purged), nil
+}
@@ -0,0 +1,104 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// queueMetricsInterval is the minimum delay between two refreshes of the
+// queue depth gauges, they require a full scan of the tasks table
+const queueMetricsInterval = 15 * time.Second
+
+// Metrics receives the events of the queue, see PrometheusMetrics for an
+// implementation
+type Metrics interface {
+	TaskEnqueued(task string)
+	TaskStarted(task string)
+	TaskSucceeded(task string)
+	TaskFailed(task string)
+	TaskRetried(task string)
+	StepDuration(task, step string, d time.Duration)
+	QueueDepth(status string, depth int64, oldestDueAge time.Duration)
+	WorkersBusy(busy, total int)
+	PollDuration(d time.Duration)
+	ClaimDuration(d time.Duration)
+}
+
+var metrics Metrics = noopMetrics{}
+
+// SetMetrics sets where queue events are reported, nil disables reporting
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metrics = m
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) TaskEnqueued(string)                        {}
+func (noopMetrics) TaskStarted(string)                         {}
+func (noopMetrics) TaskSucceeded(string)                       {}
+func (noopMetrics) TaskFailed(string)                          {}
+func (noopMetrics) TaskRetried(string)                         {}
+func (noopMetrics) StepDuration(string, string, time.Duration) {}
+func (noopMetrics) QueueDepth(string, int64, time.Duration)    {}
+func (noopMetrics) WorkersBusy(int, int)                       {}
+func (noopMetrics) PollDuration(time.Duration)                 {}
+func (noopMetrics) ClaimDuration(time.Duration)                {}
+
+// reportQueueDepth sends the number of tasks and the age of the oldest due
+// task for every status
+func reportQueueDepth(ctx context.Context, store Store) error {
+	counts, err := store.Counts(ctx)
+	if err != nil {
+		return err
+	}
+
+	depths := map[string]*NameStatusCount{}
+	for i := range counts {
+		c := &counts[i]
+		d, ok := depths[c.Status]
+		if !ok {
+			depths[c.Status] = &NameStatusCount{Status: c.Status, Count: c.Count, OldestDue: c.OldestDue}
+			continue
+		}
+		d.Count += c.Count
+		if c.OldestDue.Before(d.OldestDue) {
+			d.OldestDue = c.OldestDue
+		}
+	}
+
+	now := time.Now()
+	for _, d := range depths {
+		age := now.Sub(d.OldestDue)
+		if age < 0 {
+			age = 0
+		}
+		metrics.QueueDepth(d.Status, d.Count, age)
+	}
+
+	// Reset the statuses without any task
+	for _, status := range taskStatuses {
+		if depths[status] == nil {
+			metrics.QueueDepth(status, 0, 0)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) refreshQueueMetrics() {
+	if _, ok := metrics.(noopMetrics); ok {
+		return
+	}
+	if time.Since(s.queueMetricsAt) < queueMetricsInterval {
+		return
+	}
+	s.queueMetricsAt = time.Now()
+
+	err := reportQueueDepth(s.context(), s.store())
+	if err != nil {
+		fmt.Println("tasker: cannot refresh queue metrics:", err.Error())
+	}
+}
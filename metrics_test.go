@@ -0,0 +1,59 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+type recordMetrics struct {
+	noopMetrics
+	events []string
+}
+
+func (r *recordMetrics) TaskSucceeded(task string) { r.events = append(r.events, "succeeded:"+task) }
+func (r *recordMetrics) TaskFailed(task string)    { r.events = append(r.events, "failed:"+task) }
+func (r *recordMetrics) TaskRetried(task string)   { r.events = append(r.events, "retried:"+task) }
+func (r *recordMetrics) StepDuration(task, step string, d time.Duration) {
+	r.events = append(r.events, "step:"+task+"/"+step)
+}
+
+func TestTaskMetrics(t *testing.T) {
+	rec := &recordMetrics{}
+	SetMetrics(rec)
+	defer SetMetrics(nil)
+
+	newTask := func(exec func(t *Task) error, maxRetry int) *Task {
+		return &Task{
+			Name:     "test",
+			MaxRetry: maxRetry,
+			UserTask: &UserTask{
+				Task: &m.Task{
+					ID:     "c9f51923-293a-4e3b-a49f-cccd71db4679",
+					Status: m.TaskStatusTodo,
+				},
+			},
+			Steps: []Step{{Name: "step1", Exec: exec}},
+		}
+	}
+
+	_ = newTask(testStep, 1).Exec()
+	_ = newTask(testFailingStep, 3).Exec()
+	_ = newTask(testFailingStep, 1).Exec()
+
+	expected := []string{
+		"step:test/step1", "succeeded:test",
+		"step:test/step1", "retried:test",
+		"step:test/step1", "failed:test",
+	}
+	if len(rec.events) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, rec.events)
+	}
+	for i := range expected {
+		if rec.events[i] != expected[i] {
+			t.Errorf("Expected events %v, got %v", expected, rec.events)
+			break
+		}
+	}
+}
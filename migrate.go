@@ -0,0 +1,319 @@
+package tasker
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock held while migrating, so that
+// replicas starting together don't apply the same migration twice. The
+// second key of the lock is a hash of the migrations table.
+const migrationLockID = 725413706
+
+// noTransaction marks the migrations which cannot run in a transaction, like
+// adding a value to an enum before Postgres 12 or building an index
+// concurrently. Their statements are run one by one, each one must end with
+// a semicolon at the end of a line.
+const noTransaction = "-- tasker:no-transaction"
+
+// repeatStatement marks a statement of a no-transaction migration which runs
+// again until it affects no row, like a backfill updating a large table by
+// batches so that each batch commits on its own
+const repeatStatement = "-- tasker:repeat"
+
+// migration is a file of the migrations directory, named <version>_<name>.sql
+type migration struct {
+	Version int
+	Name    string
+	SQL     *template.Template
+}
+
+// migrationData is given to the migration templates, the table names are
+// quoted and qualified
+type migrationData struct {
+	Tasks      string
+	Attempts   string
+	RateLimits string
+	Archive    string
+	StatusType string
+	// FinishedChannel is a string literal
+	FinishedChannel string
+
+	tables Tables
+}
+
+func newMigrationData(t Tables) migrationData {
+	return migrationData{
+		Tasks:           t.qualify(t.Tasks),
+		Attempts:        t.qualify(t.Attempts),
+		RateLimits:      t.qualify(t.RateLimits),
+		Archive:         t.qualify(t.Archive),
+		StatusType:      t.qualify("task_status"),
+		FinishedChannel: "'" + strings.Replace(t.finishedChannel(), "'", "''", -1) + "'",
+		tables:          t,
+	}
+}
+
+// TasksIndex returns the name of an index of the tasks table, index names
+// are unique in a schema so they are prefixed by the table name
+func (d migrationData) TasksIndex(suffix string) string {
+	return quoteIdent(d.tables.Tasks + "_" + suffix)
+}
+
+// QualifiedTasksIndex returns the name of an index of the tasks table
+// qualified by its schema, as DROP INDEX expects it
+func (d migrationData) QualifiedTasksIndex(suffix string) string {
+	return d.tables.qualify(d.tables.Tasks + "_" + suffix)
+}
+
+// ArchiveIndex returns the name of an index of the archive table
+func (d migrationData) ArchiveIndex(suffix string) string {
+	return quoteIdent(d.tables.Archive + "_" + suffix)
+}
+
+// TasksFunction returns the qualified name of a function of the tasks table
+func (d migrationData) TasksFunction(suffix string) string {
+	return d.tables.qualify(d.tables.Tasks + "_" + suffix)
+}
+
+// AttemptsIndex returns the name of an index of the attempts table
+func (d migrationData) AttemptsIndex(suffix string) string {
+	return quoteIdent(d.tables.Attempts + "_" + suffix)
+}
+
+// loadMigrations returns the migrations of a dialect sorted by version
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("tasker: invalid migration file name %s", e.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("tasker: migrations %s and %s have the same version", other, e.Name())
+		}
+		seen[version] = e.Name()
+
+		tmpl, err := template.ParseFS(migrationFiles, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: parts[1], SQL: tmpl})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrate applies the Postgres migrations which were not applied yet to the
+// default tables, the applied versions are recorded in the
+// tasker_schema_migrations table. Migrations only ever add to the schema,
+// existing tasks are kept. Use PostgresStore.Migrate for other tables.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	return migratePostgres(ctx, db, Tables{}.withDefaults())
+}
+
+// MigrateSQLite is Migrate for the SQLiteStore schema
+func MigrateSQLite(ctx context.Context, db *sql.DB) error {
+	return migrateSQLite(ctx, db, Tables{}.withDefaults())
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// default tables, 0 when the database was never migrated
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	t := Tables{}.withDefaults()
+	return schemaVersion(ctx, db, t.qualify(t.Migrations))
+}
+
+func schemaVersion(ctx context.Context, db *sql.DB, table string) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX("version"), 0) FROM `+table).Scan(&version)
+	return version, err
+}
+
+func migratePostgres(ctx context.Context, db *sql.DB, tables Tables) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lockKey := tables.qualify(tables.Migrations)
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1, hashtext($2))`, migrationLockID, lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, migrationLockID, lockKey)
+
+	if tables.Schema != "" {
+		_, err = conn.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS `+quoteIdent(tables.Schema))
+		if err != nil {
+			return err
+		}
+	}
+
+	return migrate(ctx, conn, "postgres", tables)
+}
+
+func migrateSQLite(ctx context.Context, db *sql.DB, tables Tables) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// There are no schemas in SQLite
+	tables.Schema = ""
+	return migrate(ctx, conn, "sqlite", tables)
+}
+
+func migrate(ctx context.Context, conn *sql.Conn, dialect string, tables Tables) error {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return err
+	}
+
+	versionTable := tables.qualify(tables.Migrations)
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+versionTable+` (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX("version"), 0) FROM `+versionTable).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	data := newMigrationData(tables)
+	for _, mig := range migrations {
+		if mig.Version <= current {
+			continue
+		}
+
+		var query strings.Builder
+		err = mig.SQL.Execute(&query, data)
+		if err != nil {
+			return err
+		}
+
+		// On stderr, the standard output of the CLI is left to its results
+		fmt.Fprintf(os.Stderr, "Applying migration %04d_%s to %s\n", mig.Version, mig.Name, data.Tasks)
+		err = applyMigration(ctx, conn, versionTable, mig, query.String())
+		if err != nil {
+			return fmt.Errorf("tasker: migration %04d_%s failed: %s", mig.Version, mig.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, versionTable string, mig migration, query string) error {
+	record := `INSERT INTO ` + versionTable + ` ("version", "name") VALUES ($1, $2)`
+
+	if strings.HasPrefix(query, noTransaction) {
+		// Postgres runs the statements of a single query in a transaction
+		for _, statement := range splitStatements(query) {
+			err := execStatement(ctx, conn, statement)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := conn.ExecContext(ctx, record, mig.Version, mig.Name)
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, mig.Version, mig.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// execStatement runs a statement of a no-transaction migration, once or until
+// it affects no row when its comments mark it with repeatStatement
+func execStatement(ctx context.Context, conn *sql.Conn, statement string) error {
+	repeat := hasDirective(statement, repeatStatement)
+	for {
+		result, err := conn.ExecContext(ctx, statement)
+		if err != nil || !repeat {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil || n == 0 {
+			return err
+		}
+	}
+}
+
+// splitStatements splits a migration on the semicolons ending a line
+func splitStatements(query string) []string {
+	var statements []string
+	for _, statement := range strings.Split(query, ";\n") {
+		statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+		if statement != "" && !isComment(statement) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// hasDirective is true when one of the comments preceding a statement is the
+// directive
+func hasDirective(statement, directive string) bool {
+	for _, line := range strings.Split(statement, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+		if line == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// isComment is true when all the lines of a statement are comments
+func isComment(statement string) bool {
+	for _, line := range strings.Split(statement, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package tasker
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dialect := range []string{"postgres", "sqlite"} {
+		migrations, err := loadMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("No %s migration", dialect)
+		}
+		for i, mig := range migrations {
+			if mig.Version != i+1 {
+				t.Errorf("Expected %s migration %s to have version %d, got %d", dialect, mig.Name, i+1, mig.Version)
+			}
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSQLiteStore(db)
+	task := &m.Task{Name: "test"}
+	err = s.Enqueue(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating again is a no-op which keeps the tasks
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, _ := loadMigrations("sqlite")
+	version, err := SchemaVersion(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].Version {
+		t.Errorf("Expected version %d, got %d", migrations[len(migrations)-1].Version, version)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	err := getDBHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Migrate(ctx, dbh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, _ := loadMigrations("postgres")
+	version, err := SchemaVersion(ctx, dbh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].Version {
+		t.Errorf("Expected version %d, got %d", migrations[len(migrations)-1].Version, version)
+	}
+}
+
+func TestMigrateOutput(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = MigrateSQLite(ctx, db)
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	printed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printed) != 0 {
+		t.Errorf("Expected the migrations not to write to stdout, got %q", printed)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements(noTransaction + `
+-- The first index
+CREATE INDEX a ON t (x)
+    WHERE y = 'z';
+
+CREATE INDEX b ON t (y);
+`)
+	if len(statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %q", statements)
+	}
+	if !strings.HasSuffix(statements[0], "WHERE y = 'z'") || statements[1] != "CREATE INDEX b ON t (y)" {
+		t.Errorf("Unexpected statements %q", statements)
+	}
+}
+
+func TestRepeatStatement(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE t (x INTEGER);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 25)
+		INSERT INTO t SELECT NULL FROM n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements := splitStatements(noTransaction + `
+` + repeatStatement + `
+UPDATE t SET x = 1 WHERE rowid IN (SELECT rowid FROM t WHERE x IS NULL LIMIT 10);
+`)
+	if len(statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %q", statements)
+	}
+	err = execStatement(ctx, conn, statements[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	err = conn.QueryRowContext(ctx, `SELECT count(*) FROM t WHERE x IS NULL`).Scan(&left)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("Expected the statement to run until every row is updated, %d rows left", left)
+	}
+}
+
+func TestSQLiteDequeuePlan(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		// Claim
+		`SELECT "id" FROM "tasks" WHERE "status"='todo' AND "todo_date"<? AND "name" IN (?, ?) ORDER BY "todo_date" ASC LIMIT ?`,
+		// ClaimByKey
+		`SELECT "id" FROM "tasks" WHERE "status"='todo' AND "todo_date"<? AND "name"=? ORDER BY "todo_date" ASC`,
+		`SELECT count(*) FROM "tasks" WHERE "status"='doing' AND "name"=?`,
+		// Purge
+		`SELECT "id" FROM "tasks" WHERE "status"=? AND "todo_date"<? ORDER BY "todo_date" ASC LIMIT ?`,
+	}
+	for _, query := range queries {
+		rows, err := db.Query(`EXPLAIN QUERY PLAN `+query, time.Now(), "a", "b", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			err = rows.Scan(&id, &parent, &unused, &detail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+
+		if len(plan) == 0 || !strings.Contains(plan[0], "USING") || strings.Contains(strings.Join(plan, "\n"), "TEMP B-TREE") {
+			t.Errorf("Expected an index to be used without sorting by\n%s\ngot\n%s", query, strings.Join(plan, "\n"))
+		}
+	}
+}
@@ -0,0 +1,2 @@
+-- tasker:no-transaction
+ALTER TYPE {{.StatusType}} ADD VALUE IF NOT EXISTS 'cancelled';
@@ -0,0 +1 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS trace_context JSON;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {{.Attempts}} (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id uuid NOT NULL REFERENCES {{.Tasks}} (id) ON DELETE CASCADE,
+    step VARCHAR(255) NOT NULL,
+    retry int NOT NULL,
+    started_at timestamp NOT NULL,
+    finished_at timestamp NOT NULL,
+    error TEXT
+);
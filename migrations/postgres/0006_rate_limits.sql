@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS {{.RateLimits}} (
+    name VARCHAR(255) PRIMARY KEY,
+    window_start BIGINT NOT NULL,
+    used INTEGER NOT NULL
+);
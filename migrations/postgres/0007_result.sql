@@ -0,0 +1,15 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS result JSON;
+
+CREATE OR REPLACE FUNCTION {{.TasksFunction "notify_finished"}}() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify({{.FinishedChannel}}, NEW.id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS {{.TasksIndex "notify_finished"}} ON {{.Tasks}};
+CREATE TRIGGER {{.TasksIndex "notify_finished"}}
+    AFTER UPDATE OF status ON {{.Tasks}}
+    FOR EACH ROW
+    WHEN (NEW.status IN ('done', 'error', 'cancelled') AND OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE PROCEDURE {{.TasksFunction "notify_finished"}}();
@@ -0,0 +1,7 @@
+-- The columns added to the tasks table later must be added to the archive
+CREATE TABLE IF NOT EXISTS {{.Archive}} (
+    LIKE {{.Tasks}} INCLUDING DEFAULTS,
+    archived_at timestamp DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{.ArchiveIndex "name_created_at_idx"}} ON {{.Archive}} (name, created_at);
@@ -0,0 +1,23 @@
+-- tasker:no-transaction
+-- The indexes are built concurrently so that a large tasks table stays
+-- writable. An interrupted build leaves an invalid index which must be
+-- dropped before migrating again.
+
+-- Claim: the due todo tasks, oldest first
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.TasksIndex "todo_idx"}} ON {{.Tasks}} (todo_date)
+    WHERE status = 'todo';
+
+-- Claims of a single name, with a rate or concurrency limit
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.TasksIndex "todo_name_idx"}} ON {{.Tasks}} (name, todo_date)
+    WHERE status = 'todo';
+
+-- Tasks running for a concurrency limit
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.TasksIndex "doing_name_idx"}} ON {{.Tasks}} (name)
+    WHERE status = 'doing';
+
+-- Retention policies
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.TasksIndex "finished_idx"}} ON {{.Tasks}} (status, todo_date)
+    WHERE status IN ('done', 'error', 'cancelled');
+
+-- Attempt history and the cascade of task deletions
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.AttemptsIndex "task_id_idx"}} ON {{.Attempts}} (task_id);
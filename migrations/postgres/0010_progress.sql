@@ -0,0 +1,2 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS progress JSON;
+ALTER TABLE {{.Archive}} ADD COLUMN IF NOT EXISTS progress JSON;
@@ -0,0 +1,2 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS queue VARCHAR(255) DEFAULT '' NOT NULL;
+ALTER TABLE {{.Archive}} ADD COLUMN IF NOT EXISTS queue VARCHAR(255) DEFAULT '' NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS version INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE {{.Archive}} ADD COLUMN IF NOT EXISTS version INTEGER DEFAULT 0 NOT NULL;
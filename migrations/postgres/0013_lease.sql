@@ -0,0 +1,9 @@
+-- tasker:no-transaction
+-- The doing tasks get a lease renewed while they run, the tasks whose lease
+-- expired, like the ones of a crashed scheduler, are claimed again. The tasks
+-- claimed before this migration have no lease and never expire.
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS lease_until timestamp;
+ALTER TABLE {{.Archive}} ADD COLUMN IF NOT EXISTS lease_until timestamp;
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.TasksIndex "lease_idx"}} ON {{.Tasks}} (lease_until)
+    WHERE status = 'doing';
@@ -0,0 +1,20 @@
+-- tasker:no-transaction
+-- The retention policies age the tasks from the date they reached a final
+-- status. The tasks which finished before this migration use their todo
+-- date, the date of their last execution.
+ALTER TABLE {{.Tasks}} ADD COLUMN IF NOT EXISTS finished_at timestamp;
+ALTER TABLE {{.Archive}} ADD COLUMN IF NOT EXISTS finished_at timestamp;
+
+-- tasker:repeat
+-- By batches, the rows of a large tasks table are not all locked at once
+UPDATE {{.Tasks}} SET finished_at = todo_date WHERE id IN (
+    SELECT id FROM {{.Tasks}}
+    WHERE status IN ('done', 'error', 'cancelled') AND finished_at IS NULL
+    LIMIT 10000
+);
+
+CREATE INDEX CONCURRENTLY IF NOT EXISTS {{.TasksIndex "finished_at_idx"}} ON {{.Tasks}} (status, finished_at)
+    WHERE status IN ('done', 'error', 'cancelled');
+
+-- Replaced by finished_at_idx, the retention policies no longer use the todo date
+DROP INDEX CONCURRENTLY IF EXISTS {{.QualifiedTasksIndex "finished_idx"}};
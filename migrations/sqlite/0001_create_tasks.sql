@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS {{.Tasks}} (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    todo_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    actual_step VARCHAR(255) NOT NULL,
+    status TEXT DEFAULT 'todo' NOT NULL CHECK (status IN ('todo', 'error', 'done', 'doing', 'cancelled')),
+    retry INTEGER DEFAULT 0 NOT NULL,
+    user_buffer JSON,
+    user_args JSON,
+    trace_context JSON
+);
+
+CREATE INDEX IF NOT EXISTS {{.TasksIndex "status_todo_date_idx"}} ON {{.Tasks}} (status, todo_date);
+
+CREATE TABLE IF NOT EXISTS {{.Attempts}} (
+    id TEXT PRIMARY KEY,
+    task_id TEXT NOT NULL REFERENCES {{.Tasks}} (id) ON DELETE CASCADE,
+    step VARCHAR(255) NOT NULL,
+    retry INTEGER NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS {{.AttemptsIndex "task_id_idx"}} ON {{.Attempts}} (task_id);
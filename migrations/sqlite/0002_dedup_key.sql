@@ -0,0 +1,3 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN dedup_key VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS {{.TasksIndex "name_dedup_key_idx"}} ON {{.Tasks}} (name, dedup_key);
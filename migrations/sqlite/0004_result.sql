@@ -0,0 +1 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN result JSON;
@@ -0,0 +1,18 @@
+-- The columns added to the tasks table later must be added to the archive
+CREATE TABLE IF NOT EXISTS {{.Archive}} (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    todo_date TIMESTAMP NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    actual_step VARCHAR(255) NOT NULL,
+    status TEXT NOT NULL,
+    retry INTEGER NOT NULL,
+    user_buffer JSON,
+    user_args JSON,
+    trace_context JSON,
+    dedup_key VARCHAR(255),
+    result JSON,
+    archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {{.ArchiveIndex "name_created_at_idx"}} ON {{.Archive}} (name, created_at);
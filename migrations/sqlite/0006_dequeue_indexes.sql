@@ -0,0 +1,7 @@
+-- Claims of a single name, with a rate or concurrency limit
+CREATE INDEX IF NOT EXISTS {{.TasksIndex "todo_name_idx"}} ON {{.Tasks}} (name, todo_date)
+    WHERE status = 'todo';
+
+-- Tasks running for a concurrency limit
+CREATE INDEX IF NOT EXISTS {{.TasksIndex "doing_name_idx"}} ON {{.Tasks}} (name)
+    WHERE status = 'doing';
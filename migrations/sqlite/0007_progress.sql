@@ -0,0 +1,2 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN progress JSON;
+ALTER TABLE {{.Archive}} ADD COLUMN progress JSON;
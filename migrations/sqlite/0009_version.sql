@@ -0,0 +1,2 @@
+ALTER TABLE {{.Tasks}} ADD COLUMN version INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE {{.Archive}} ADD COLUMN version INTEGER DEFAULT 0 NOT NULL;
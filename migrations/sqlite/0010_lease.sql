@@ -0,0 +1,7 @@
+-- The doing tasks get a lease renewed while they run, the tasks whose lease
+-- expired, like the ones of a crashed scheduler, are claimed again
+ALTER TABLE {{.Tasks}} ADD COLUMN lease_until TIMESTAMP;
+ALTER TABLE {{.Archive}} ADD COLUMN lease_until TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS {{.TasksIndex "lease_idx"}} ON {{.Tasks}} (lease_until)
+    WHERE status = 'doing';
@@ -0,0 +1,11 @@
+-- The retention policies age the tasks from the date they reached a final
+-- status. The tasks which finished before this migration use their todo
+-- date, the date of their last execution.
+ALTER TABLE {{.Tasks}} ADD COLUMN finished_at TIMESTAMP;
+ALTER TABLE {{.Archive}} ADD COLUMN finished_at TIMESTAMP;
+
+UPDATE {{.Tasks}} SET finished_at = todo_date
+    WHERE status IN ('done', 'error', 'cancelled') AND finished_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS {{.TasksIndex "finished_at_idx"}} ON {{.Tasks}} (status, finished_at)
+    WHERE status IN ('done', 'error', 'cancelled');
@@ -53,8 +53,9 @@ func makeCacheKey(cols boil.Columns, nzDefaults []string) string {
 
 // Enum values for task_status
 const (
-	TaskStatusTodo  = "todo"
-	TaskStatusError = "error"
-	TaskStatusDone  = "done"
-	TaskStatusDoing = "doing"
+	TaskStatusTodo      = "todo"
+	TaskStatusError     = "error"
+	TaskStatusDone      = "done"
+	TaskStatusDoing     = "doing"
+	TaskStatusCancelled = "cancelled"
 )
@@ -24,40 +24,64 @@ import (
 
 // Task is an object representing the database table.
 type Task struct {
-	ID         string    `boil:"id" json:"id" toml:"id" yaml:"id"`
-	CreatedAt  time.Time `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
-	TodoDate   time.Time `boil:"todo_date" json:"todo_date" toml:"todo_date" yaml:"todo_date"`
-	Name       string    `boil:"name" json:"name" toml:"name" yaml:"name"`
-	ActualStep string    `boil:"actual_step" json:"actual_step" toml:"actual_step" yaml:"actual_step"`
-	Status     string    `boil:"status" json:"status" toml:"status" yaml:"status"`
-	Retry      int       `boil:"retry" json:"retry" toml:"retry" yaml:"retry"`
-	UserBuffer null.JSON `boil:"user_buffer" json:"user_buffer,omitempty" toml:"user_buffer" yaml:"user_buffer,omitempty"`
-	UserArgs   null.JSON `boil:"user_args" json:"user_args,omitempty" toml:"user_args" yaml:"user_args,omitempty"`
+	ID           string      `boil:"id" json:"id" toml:"id" yaml:"id"`
+	CreatedAt    time.Time   `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
+	TodoDate     time.Time   `boil:"todo_date" json:"todo_date" toml:"todo_date" yaml:"todo_date"`
+	Name         string      `boil:"name" json:"name" toml:"name" yaml:"name"`
+	ActualStep   string      `boil:"actual_step" json:"actual_step" toml:"actual_step" yaml:"actual_step"`
+	Status       string      `boil:"status" json:"status" toml:"status" yaml:"status"`
+	Retry        int         `boil:"retry" json:"retry" toml:"retry" yaml:"retry"`
+	UserBuffer   null.JSON   `boil:"user_buffer" json:"user_buffer,omitempty" toml:"user_buffer" yaml:"user_buffer,omitempty"`
+	UserArgs     null.JSON   `boil:"user_args" json:"user_args,omitempty" toml:"user_args" yaml:"user_args,omitempty"`
+	TraceContext null.JSON   `boil:"trace_context" json:"trace_context,omitempty" toml:"trace_context" yaml:"trace_context,omitempty"`
+	DedupKey     null.String `boil:"dedup_key" json:"dedup_key,omitempty" toml:"dedup_key" yaml:"dedup_key,omitempty"`
+	Result       null.JSON   `boil:"result" json:"result,omitempty" toml:"result" yaml:"result,omitempty"`
+	Progress     null.JSON   `boil:"progress" json:"progress,omitempty" toml:"progress" yaml:"progress,omitempty"`
+	Queue        string      `boil:"queue" json:"queue" toml:"queue" yaml:"queue"`
+	Version      int         `boil:"version" json:"version" toml:"version" yaml:"version"`
+	LeaseUntil   null.Time   `boil:"lease_until" json:"lease_until,omitempty" toml:"lease_until" yaml:"lease_until,omitempty"`
+	FinishedAt   null.Time   `boil:"finished_at" json:"finished_at,omitempty" toml:"finished_at" yaml:"finished_at,omitempty"`
 
 	R *taskR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L taskL  `boil:"-" json:"-" toml:"-" yaml:"-"`
 }
 
 var TaskColumns = struct {
-	ID         string
-	CreatedAt  string
-	TodoDate   string
-	Name       string
-	ActualStep string
-	Status     string
-	Retry      string
-	UserBuffer string
-	UserArgs   string
+	ID           string
+	CreatedAt    string
+	TodoDate     string
+	Name         string
+	ActualStep   string
+	Status       string
+	Retry        string
+	UserBuffer   string
+	UserArgs     string
+	TraceContext string
+	DedupKey     string
+	Result       string
+	Progress     string
+	Queue        string
+	Version      string
+	LeaseUntil   string
+	FinishedAt   string
 }{
-	ID:         "id",
-	CreatedAt:  "created_at",
-	TodoDate:   "todo_date",
-	Name:       "name",
-	ActualStep: "actual_step",
-	Status:     "status",
-	Retry:      "retry",
-	UserBuffer: "user_buffer",
-	UserArgs:   "user_args",
+	ID:           "id",
+	CreatedAt:    "created_at",
+	TodoDate:     "todo_date",
+	Name:         "name",
+	ActualStep:   "actual_step",
+	Status:       "status",
+	Retry:        "retry",
+	UserBuffer:   "user_buffer",
+	UserArgs:     "user_args",
+	TraceContext: "trace_context",
+	DedupKey:     "dedup_key",
+	Result:       "result",
+	Progress:     "progress",
+	Queue:        "queue",
+	Version:      "version",
+	LeaseUntil:   "lease_until",
+	FinishedAt:   "finished_at",
 }
 
 // Generated where
@@ -138,26 +162,88 @@ func (w whereHelpernull_JSON) GTE(x null.JSON) qm.QueryMod {
 	return qmhelper.Where(w.field, qmhelper.GTE, x)
 }
 
+type whereHelpernull_String struct{ field string }
+
+func (w whereHelpernull_String) EQ(x null.String) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_String) NEQ(x null.String) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_String) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_String) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+func (w whereHelpernull_String) LT(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_String) LTE(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_String) GT(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_String) GTE(x null.String) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
+type whereHelpernull_Time struct{ field string }
+
+func (w whereHelpernull_Time) EQ(x null.Time) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_Time) NEQ(x null.Time) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_Time) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_Time) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+func (w whereHelpernull_Time) LT(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_Time) LTE(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_Time) GT(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_Time) GTE(x null.Time) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
 var TaskWhere = struct {
-	ID         whereHelperstring
-	CreatedAt  whereHelpertime_Time
-	TodoDate   whereHelpertime_Time
-	Name       whereHelperstring
-	ActualStep whereHelperstring
-	Status     whereHelperstring
-	Retry      whereHelperint
-	UserBuffer whereHelpernull_JSON
-	UserArgs   whereHelpernull_JSON
+	ID           whereHelperstring
+	CreatedAt    whereHelpertime_Time
+	TodoDate     whereHelpertime_Time
+	Name         whereHelperstring
+	ActualStep   whereHelperstring
+	Status       whereHelperstring
+	Retry        whereHelperint
+	UserBuffer   whereHelpernull_JSON
+	UserArgs     whereHelpernull_JSON
+	TraceContext whereHelpernull_JSON
+	DedupKey     whereHelpernull_String
+	Result       whereHelpernull_JSON
+	Progress     whereHelpernull_JSON
+	Queue        whereHelperstring
+	Version      whereHelperint
+	LeaseUntil   whereHelpernull_Time
+	FinishedAt   whereHelpernull_Time
 }{
-	ID:         whereHelperstring{field: "\"tasks\".\"id\""},
-	CreatedAt:  whereHelpertime_Time{field: "\"tasks\".\"created_at\""},
-	TodoDate:   whereHelpertime_Time{field: "\"tasks\".\"todo_date\""},
-	Name:       whereHelperstring{field: "\"tasks\".\"name\""},
-	ActualStep: whereHelperstring{field: "\"tasks\".\"actual_step\""},
-	Status:     whereHelperstring{field: "\"tasks\".\"status\""},
-	Retry:      whereHelperint{field: "\"tasks\".\"retry\""},
-	UserBuffer: whereHelpernull_JSON{field: "\"tasks\".\"user_buffer\""},
-	UserArgs:   whereHelpernull_JSON{field: "\"tasks\".\"user_args\""},
+	ID:           whereHelperstring{field: "\"tasks\".\"id\""},
+	CreatedAt:    whereHelpertime_Time{field: "\"tasks\".\"created_at\""},
+	TodoDate:     whereHelpertime_Time{field: "\"tasks\".\"todo_date\""},
+	Name:         whereHelperstring{field: "\"tasks\".\"name\""},
+	ActualStep:   whereHelperstring{field: "\"tasks\".\"actual_step\""},
+	Status:       whereHelperstring{field: "\"tasks\".\"status\""},
+	Retry:        whereHelperint{field: "\"tasks\".\"retry\""},
+	UserBuffer:   whereHelpernull_JSON{field: "\"tasks\".\"user_buffer\""},
+	UserArgs:     whereHelpernull_JSON{field: "\"tasks\".\"user_args\""},
+	TraceContext: whereHelpernull_JSON{field: "\"tasks\".\"trace_context\""},
+	DedupKey:     whereHelpernull_String{field: "\"tasks\".\"dedup_key\""},
+	Result:       whereHelpernull_JSON{field: "\"tasks\".\"result\""},
+	Progress:     whereHelpernull_JSON{field: "\"tasks\".\"progress\""},
+	Queue:        whereHelperstring{field: "\"tasks\".\"queue\""},
+	Version:      whereHelperint{field: "\"tasks\".\"version\""},
+	LeaseUntil:   whereHelpernull_Time{field: "\"tasks\".\"lease_until\""},
+	FinishedAt:   whereHelpernull_Time{field: "\"tasks\".\"finished_at\""},
 }
 
 // TaskRels is where relationship names are stored.
@@ -177,9 +263,9 @@ func (*taskR) NewStruct() *taskR {
 type taskL struct{}
 
 var (
-	taskAllColumns            = []string{"id", "created_at", "todo_date", "name", "actual_step", "status", "retry", "user_buffer", "user_args"}
-	taskColumnsWithoutDefault = []string{"name", "actual_step", "user_buffer", "user_args"}
-	taskColumnsWithDefault    = []string{"id", "created_at", "todo_date", "status", "retry"}
+	taskAllColumns            = []string{"id", "created_at", "todo_date", "name", "actual_step", "status", "retry", "user_buffer", "user_args", "trace_context", "dedup_key", "result", "progress", "queue", "version", "lease_until", "finished_at"}
+	taskColumnsWithoutDefault = []string{"name", "actual_step", "user_buffer", "user_args", "trace_context", "dedup_key", "result", "progress", "lease_until", "finished_at"}
+	taskColumnsWithDefault    = []string{"id", "created_at", "todo_date", "status", "retry", "queue", "version"}
 	taskPrimaryKeyColumns     = []string{"id"}
 )
 
@@ -427,7 +427,7 @@ func testTasksSelect(t *testing.T) {
 }
 
 var (
-	taskDBTypes = map[string]string{`ID`: `uuid`, `CreatedAt`: `timestamp without time zone`, `TodoDate`: `timestamp without time zone`, `Name`: `character varying`, `ActualStep`: `character varying`, `Status`: `enum.task_status('todo','error','done','doing')`, `Retry`: `integer`, `UserBuffer`: `json`, `UserArgs`: `json`}
+	taskDBTypes = map[string]string{`ID`: `uuid`, `CreatedAt`: `timestamp without time zone`, `TodoDate`: `timestamp without time zone`, `Name`: `character varying`, `ActualStep`: `character varying`, `Status`: `enum.task_status('todo','error','done','doing','cancelled')`, `Retry`: `integer`, `UserBuffer`: `json`, `UserArgs`: `json`, `TraceContext`: `json`, `DedupKey`: `character varying`, `Result`: `json`, `Progress`: `json`, `Queue`: `character varying`, `Version`: `integer`, `LeaseUntil`: `timestamp without time zone`, `FinishedAt`: `timestamp without time zone`}
 	_           = bytes.MinRead
 )
 
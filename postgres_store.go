@@ -0,0 +1,588 @@
+package tasker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// PostgresStore is a Store backed by Postgres, the queries are written by
+// hand since the sqlboiler models can only use the default table names
+type PostgresStore struct {
+	// Tables can be changed before the store is used, the default tables are
+	// used when empty
+	Tables Tables
+
+	db       *sql.DB
+	mu       sync.Mutex
+	listener *pq.Listener
+	waiters  map[string][]chan struct{}
+}
+
+// NewPostgresStore returns a Store using the given connection
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) tasks() string {
+	t := s.Tables.withDefaults()
+	return t.qualify(t.Tasks)
+}
+
+func (s *PostgresStore) attempts() string {
+	t := s.Tables.withDefaults()
+	return t.qualify(t.Attempts)
+}
+
+func (s *PostgresStore) archive() string {
+	t := s.Tables.withDefaults()
+	return t.qualify(t.Archive)
+}
+
+func (s *PostgresStore) rateLimits() string {
+	t := s.Tables.withDefaults()
+	return t.qualify(t.RateLimits)
+}
+
+// ListenForResults listens to the notifications sent by Postgres when a task
+// finishes, WaitForResult returns as soon as they arrive instead of polling
+// only. The notifications go through a dedicated connection opened with dsn.
+func (s *PostgresStore) ListenForResults(dsn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return nil
+	}
+
+	l := pq.NewListener(dsn, time.Second, time.Minute, nil)
+	err := l.Listen(s.Tables.withDefaults().finishedChannel())
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.listener = l
+	s.waiters = map[string][]chan struct{}{}
+	go s.dispatchFinished(l)
+	return nil
+}
+
+// dispatchFinished wakes up the waiters of the finished tasks, a nil
+// notification means the connection was lost and wakes up everybody
+func (s *PostgresStore) dispatchFinished(l *pq.Listener) {
+	for n := range l.Notify {
+		s.mu.Lock()
+		for id, waiters := range s.waiters {
+			if n != nil && n.Extra != id {
+				continue
+			}
+			for _, c := range waiters {
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *PostgresStore) notifyFinished(id string) (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil, func() {}
+	}
+
+	c := make(chan struct{}, 1)
+	s.waiters[id] = append(s.waiters[id], c)
+	return c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		waiters := s.waiters[id]
+		for i := range waiters {
+			if waiters[i] == c {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(s.waiters, id)
+		} else {
+			s.waiters[id] = waiters
+		}
+	}
+}
+
+// pgPlaceholders returns n comma separated placeholders numbered from start
+func pgPlaceholders(n, start int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = "$" + strconv.Itoa(start+i)
+	}
+	return strings.Join(list, ", ")
+}
+
+// Migrate applies the migrations of the store tables, see Migrate
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	return migratePostgres(ctx, s.db, s.Tables.withDefaults())
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// store tables
+func (s *PostgresStore) SchemaVersion(ctx context.Context) (int, error) {
+	t := s.Tables.withDefaults()
+	return schemaVersion(ctx, s.db, t.qualify(t.Migrations))
+}
+
+// pgEnqueueBatch is the number of tasks inserted by a single statement, it
+// keeps the number of parameters under the Postgres limit
+const pgEnqueueBatch = 1000
+
+// Enqueue implements Store, the tasks are inserted with multi-row inserts in
+// a single transaction
+func (s *PostgresStore) Enqueue(ctx context.Context, tasks ...*m.Task) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(tasks); start += pgEnqueueBatch {
+		end := start + pgEnqueueBatch
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		err = s.enqueueBatch(ctx, tx, tasks[start:end])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) enqueueBatch(ctx context.Context, tx *sql.Tx, tasks []*m.Task) error {
+	columns := append([]string{m.TaskColumns.ID}, taskColumns...)
+	rows := make([]string, 0, len(tasks))
+	args := make([]interface{}, 0, len(tasks)*len(columns))
+	for _, task := range tasks {
+		err := setEnqueueDefaults(task)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, "("+pgPlaceholders(len(columns), len(args)+1)+")")
+		args = append(args, task.ID)
+		args = append(args, taskValues(task)...)
+	}
+
+	result, err := tx.QueryContext(ctx, `INSERT INTO `+s.tasks()+` ("`+strings.Join(columns, `", "`)+`")
+		VALUES `+strings.Join(rows, ", ")+`
+		ON CONFLICT ("name", "dedup_key") DO NOTHING
+		RETURNING "id", "created_at"`, args...)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	inserted := map[string]bool{}
+	createdAt := map[string]time.Time{}
+	for result.Next() {
+		var id string
+		var date time.Time
+		err = result.Scan(&id, &date)
+		if err != nil {
+			return err
+		}
+		inserted[id] = true
+		createdAt[id] = date
+	}
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, task := range tasks {
+		if inserted[task.ID] {
+			task.CreatedAt = createdAt[task.ID]
+		} else {
+			keys = append(keys, task.DedupKey.String)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// The tasks which were not inserted are duplicates
+	var existing m.TaskSlice
+	err = queries.Raw(`SELECT * FROM `+s.tasks()+` WHERE "dedup_key"=ANY($1)`, pq.Array(keys)).
+		Bind(ctx, tx, &existing)
+	if err != nil {
+		return err
+	}
+	return replaceDuplicates(tasks, inserted, existing)
+}
+
+// Claim implements Store, concurrent schedulers skip the rows locked by
+// each other
+func (s *PostgresStore) Claim(ctx context.Context, names, queues []string, lease time.Time, limit int) (m.TaskSlice, error) {
+	err := s.requeueExpired(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks m.TaskSlice
+	err = queries.Raw(`UPDATE `+s.tasks()+` SET "status"='doing', "lease_until"=$5 WHERE "id" IN (
+		SELECT "id" FROM `+s.tasks()+`
+		WHERE "status"='todo' AND "todo_date"<$1 AND "name"=ANY($2) AND "queue"=ANY($3)
+		ORDER BY "todo_date" ASC LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	) RETURNING *`, time.Now(), pq.Array(names), pq.Array(queues), limit, lease).Bind(ctx, s.db, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// requeueExpired puts back to todo the doing tasks whose lease expired and
+// records the lost execution as a failed attempt, the rows locked by another
+// requeue are left to it
+func (s *PostgresStore) requeueExpired(ctx context.Context, exec boil.ContextExecutor) error {
+	_, err := exec.ExecContext(ctx, `WITH "requeued" AS (
+		UPDATE `+s.tasks()+` SET "status"='todo', "retry"="retry"+1 WHERE "id" IN (
+			SELECT "id" FROM `+s.tasks()+` WHERE "status"='doing' AND "lease_until"<$1
+			FOR UPDATE SKIP LOCKED
+		) AND "status"='doing' RETURNING "id", "actual_step", "retry"
+	) INSERT INTO `+s.attempts()+` ("task_id", "step", "retry", "started_at", "finished_at", "error")
+		SELECT "id", "actual_step", "retry"-1, $1, $1, $2 FROM "requeued"`, time.Now(), ErrLeaseExpired.Error())
+	return err
+}
+
+// ExtendLease implements Store
+func (s *PostgresStore) ExtendLease(ctx context.Context, lease time.Time, ids ...string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE `+s.tasks()+` SET "lease_until"=$1 WHERE "status"='doing' AND "id"=ANY($2)`,
+		lease, pq.Array(ids))
+	return err
+}
+
+// claimLockID is the Postgres advisory lock serializing the claims of a task
+// with a concurrency limit, the second key is a hash of the table and task
+const claimLockID = 725413707
+
+// ClaimByKey implements Store, the claims of the task are serialized by an
+// advisory lock so that each sees the tasks claimed by the previous one
+func (s *PostgresStore) ClaimByKey(ctx context.Context, name string, queues []string, key string, max int, lease time.Time, limit int) (m.TaskSlice, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, claimLockID, s.tasks()+"/"+name)
+	if err != nil {
+		return nil, err
+	}
+	// The expired tasks don't hold their key anymore
+	err = s.requeueExpired(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks m.TaskSlice
+	err = queries.Raw(`UPDATE `+s.tasks()+` SET "status"='doing', "lease_until"=$7 WHERE "status"='todo' AND "id" IN (
+		SELECT "id" FROM (
+			SELECT "id", "todo_date", "user_args"->>$3::text AS "key",
+				row_number() OVER (PARTITION BY "user_args"->>$3::text ORDER BY "todo_date") AS "rank"
+			FROM `+s.tasks()+` WHERE "status"='todo' AND "todo_date"<$1 AND "name"=$2 AND "queue"=ANY($6)
+		) AS c WHERE c."key" IS NULL OR c."rank" <= $4 - (
+			SELECT count(*) FROM `+s.tasks()+` AS d
+			WHERE d."status"='doing' AND d."name"=$2 AND d."user_args"->>$3::text=c."key"
+		)
+		ORDER BY c."todo_date" ASC LIMIT $5
+	) RETURNING *`, time.Now(), name, key, max, limit, pq.Array(queues), lease).Bind(ctx, tx, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, tx.Commit()
+}
+
+// Update implements Store, many tasks are updated by a single statement
+// reading their new values from a JSON array
+func (s *PostgresStore) Update(ctx context.Context, columns []string, tasks ...*m.Task) error {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+	if len(tasks) == 1 {
+		return s.updateOne(ctx, columns, tasks[0])
+	}
+
+	err := checkColumns(columns)
+	if err != nil {
+		return err
+	}
+	sets := make([]string, len(columns))
+	for i, c := range columns {
+		sets[i] = `"` + c + `"=v."` + c + `"`
+	}
+
+	rows, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE `+s.tasks()+` AS t SET `+strings.Join(sets, ", ")+`
+		FROM json_populate_recordset(NULL::`+s.tasks()+`, $1) AS v WHERE t."id"=v."id"`, string(rows))
+	return err
+}
+
+// UpdateClaimed implements Store, the claims are checked by the statement
+// updating the tasks
+func (s *PostgresStore) UpdateClaimed(ctx context.Context, columns []string, tasks ...ClaimedTask) ([]string, error) {
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+	err := checkColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]string, len(columns))
+	for i, c := range columns {
+		sets[i] = `"` + c + `"=v."` + c + `"`
+	}
+
+	rows := make(m.TaskSlice, len(tasks))
+	ids := make([]string, len(tasks))
+	retries := make([]int64, len(tasks))
+	for i, c := range tasks {
+		rows[i] = c.Task
+		ids[i] = c.Task.ID
+		retries[i] = int64(c.Retry)
+	}
+	values, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.QueryContext(ctx, `UPDATE `+s.tasks()+` AS t SET `+strings.Join(sets, ", ")+`
+		FROM json_populate_recordset(NULL::`+s.tasks()+`, $1) AS v, unnest($2::uuid[], $3::int[]) AS c("id", "retry")
+		WHERE t."id"=v."id" AND t."id"=c."id" AND t."status"='doing' AND t."retry"=c."retry"
+		RETURNING t."id"`, string(values), pq.Array(ids), pq.Array(retries))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	updated := map[string]bool{}
+	for result.Next() {
+		var id string
+		err := result.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		updated[id] = true
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return lostClaims(tasks, updated), nil
+}
+
+func (s *PostgresStore) updateOne(ctx context.Context, columns []string, task *m.Task) error {
+	_, err := s.updateWhere(ctx, columns, task, nil)
+	return err
+}
+
+// UpdateIf implements Store
+func (s *PostgresStore) UpdateIf(ctx context.Context, columns []string, task *m.Task, statuses ...string) error {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+	updated, err := s.updateWhere(ctx, columns, task, statuses)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrInvalidStatus
+	}
+	return nil
+}
+
+// updateWhere updates a task, only while its status is one of statuses when
+// set, and returns the number of updated rows
+func (s *PostgresStore) updateWhere(ctx context.Context, columns []string, task *m.Task, statuses []string) (int64, error) {
+	values, err := taskColumnValues(task, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	sets := make([]string, len(columns))
+	for i, c := range columns {
+		sets[i] = `"` + c + `"=$` + strconv.Itoa(i+1)
+	}
+
+	values = append(values, task.ID)
+	where := `"id"=$` + strconv.Itoa(len(values))
+	if statuses != nil {
+		values = append(values, pq.Array(statuses))
+		where += ` AND "status"::text=ANY($` + strconv.Itoa(len(values)) + `)`
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE `+s.tasks()+` SET `+strings.Join(sets, ", ")+` WHERE `+where, values...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Get implements Store
+func (s *PostgresStore) Get(ctx context.Context, id string) (*m.Task, error) {
+	if _, err := uuid.FromString(id); err != nil {
+		return nil, ErrTaskNotFound
+	}
+
+	task := &m.Task{}
+	err := queries.Raw(`SELECT * FROM `+s.tasks()+` WHERE "id"=$1`, id).Bind(ctx, s.db, task)
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// List implements Store
+func (s *PostgresStore) List(ctx context.Context, f TaskFilter) (m.TaskSlice, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, cond+"$"+strconv.Itoa(len(args)))
+	}
+	if f.Name != "" {
+		add(`"name"=`, f.Name)
+	}
+	if f.Status != "" {
+		add(`"status"=`, f.Status)
+	}
+	if !f.After.IsZero() {
+		add(`"todo_date">=`, f.After)
+	}
+	if !f.Before.IsZero() {
+		add(`"todo_date"<`, f.Before)
+	}
+
+	query := `SELECT * FROM ` + s.tasks()
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY "created_at" DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(f.Limit)
+	}
+	if f.Offset > 0 {
+		query += ` OFFSET ` + strconv.Itoa(f.Offset)
+	}
+
+	var tasks m.TaskSlice
+	err := queries.Raw(query, args...).Bind(ctx, s.db, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Counts implements Store
+func (s *PostgresStore) Counts(ctx context.Context) ([]NameStatusCount, error) {
+	var counts []NameStatusCount
+	err := queries.Raw(`SELECT "name", "status", count(*) AS "count", min("todo_date") AS "oldest_due"
+		FROM `+s.tasks()+` GROUP BY "name", "status" ORDER BY "name", "status"`).Bind(ctx, s.db, &counts)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// PendingSteps implements Store
+func (s *PostgresStore) PendingSteps(ctx context.Context) ([]StepCount, error) {
+	var counts []StepCount
+	err := queries.Raw(`SELECT "name", "version", "actual_step", count(*) AS "count"
+		FROM `+s.tasks()+` WHERE "status" IN ('todo', 'doing')
+		GROUP BY "name", "version", "actual_step" ORDER BY "name", "version", "actual_step"`).Bind(ctx, s.db, &counts)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// AddAttempt implements Store
+func (s *PostgresStore) AddAttempt(ctx context.Context, a *Attempt) error {
+	return s.db.QueryRowContext(ctx,
+		`INSERT INTO `+s.attempts()+` ("task_id", "step", "retry", "started_at", "finished_at", "error") VALUES ($1, $2, $3, $4, $5, $6) RETURNING "id"`,
+		a.TaskID, a.Step, a.Retry, a.StartedAt, a.FinishedAt, a.Error,
+	).Scan(&a.ID)
+}
+
+// Attempts implements Store
+func (s *PostgresStore) Attempts(ctx context.Context, taskID string) ([]*Attempt, error) {
+	var attempts []*Attempt
+	err := queries.Raw(`SELECT * FROM `+s.attempts()+` WHERE "task_id"=$1 ORDER BY "started_at" ASC`, taskID).
+		Bind(ctx, s.db, &attempts)
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Throughput implements Store
+func (s *PostgresStore) Throughput(ctx context.Context, since time.Time) ([]*ThroughputBucket, error) {
+	var buckets []*ThroughputBucket
+	err := queries.Raw(`SELECT date_trunc('hour', "finished_at") AS "hour",
+		count(*) FILTER (WHERE "error" IS NULL) AS "succeeded",
+		count(*) FILTER (WHERE "error" IS NOT NULL) AS "failed"
+		FROM `+s.attempts()+` WHERE "finished_at" >= $1
+		GROUP BY 1 ORDER BY 1`, since).Bind(ctx, s.db, &buckets)
+	if err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// UseTokens implements Store, the row of the name is locked until the tokens
+// are taken
+func (s *PostgresStore) UseTokens(ctx context.Context, name string, window time.Time, max, n int) (int, error) {
+	return useTokens(ctx, s.db, s.rateLimits(), ` FOR UPDATE`, name, window, max, n)
+}
+
+// Purge implements Store, the tasks are moved to the archive by a single
+// statement
+func (s *PostgresStore) Purge(ctx context.Context, status string, before time.Time, archive bool, limit int) (int, error) {
+	query := `DELETE FROM ` + s.tasks() + ` WHERE "id" IN (
+		SELECT "id" FROM ` + s.tasks() + `
+		WHERE "status"=$1 AND "finished_at"<$2
+		ORDER BY "finished_at" ASC LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)`
+	if archive {
+		columns := archiveColumns()
+		query = `WITH "purged" AS (` + query + ` RETURNING *)
+			INSERT INTO ` + s.archive() + ` (` + columns + `) SELECT ` + columns + ` FROM "purged"`
+	}
+
+	result, err := s.db.ExecContext(ctx, query, status, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,88 @@
+package tasker
+
+import (
+	"context"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// DefaultProgressInterval is the minimum delay between two saves of the
+// progress of a task when the scheduler ProgressInterval is 0
+const DefaultProgressInterval = 5 * time.Second
+
+// Progress is the advancement of the running step of a task, it is cleared
+// when the task moves to the next step
+type Progress struct {
+	Current   int64     `json:"current"`
+	Total     int64     `json:"total"`
+	Message   string    `json:"message,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Percent returns the progress between 0 and 100, 0 when the total is unknown
+func (p *Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Current) * 100 / float64(p.Total)
+}
+
+// TaskProgress returns the progress reported by the step of a task, nil when
+// the step didn't report any
+func TaskProgress(task *m.Task) (*Progress, error) {
+	if !task.Progress.Valid {
+		return nil, nil
+	}
+	p := &Progress{}
+	err := task.Progress.Unmarshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetProgress returns the progress of a task, nil when its step didn't report
+// any
+func GetProgress(ctx context.Context, id string) (*Progress, error) {
+	task, err := GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return TaskProgress(task)
+}
+
+// ReportProgress sets the progress of the running step. It is saved at most
+// once per progress interval, when current reaches total and with the task
+// at the end of the step.
+func (t *Task) ReportProgress(current, total int64, message string) error {
+	u := t.UserTask
+	if u == nil {
+		return ErrNilUserTask
+	}
+
+	err := u.Progress.Marshal(&Progress{Current: current, Total: total, Message: message, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if current < total && time.Since(u.progressSavedAt) < t.progressInterval() {
+		return nil
+	}
+
+	err = u.write(t.Context(), []string{m.TaskColumns.Progress})
+	if err != nil {
+		return err
+	}
+	u.progressSavedAt = time.Now()
+	if u.saved != nil {
+		u.saved.Progress = copyTask(u.Task).Progress
+	}
+	return nil
+}
+
+func (t *Task) progressInterval() time.Duration {
+	if t.scheduler == nil || t.scheduler.ProgressInterval <= 0 {
+		return DefaultProgressInterval
+	}
+	return t.scheduler.ProgressInterval
+}
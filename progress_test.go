@@ -0,0 +1,76 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestReportProgress(t *testing.T) {
+	useMemoryStore(t)
+
+	saved := func(task *Task) *Progress {
+		got, err := GetTask(ctx, task.UserTask.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := TaskProgress(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	var afterStep *Progress
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name: "migration",
+			Steps: []Step{{Name: "migrate", Exec: func(task *Task) error {
+				for _, c := range []struct{ current, saved int64 }{{1, 1}, {2, 1}, {10, 10}} {
+					err := task.ReportProgress(c.current, 10, "copying rows")
+					if err != nil {
+						return err
+					}
+					if p := saved(task); p == nil || p.Current != c.saved || p.Message != "copying rows" {
+						t.Errorf("Expected the progress at %d to be saved, got %+v", c.saved, p)
+					}
+				}
+				return nil
+			}}, {Name: "check", Exec: func(task *Task) error {
+				afterStep = saved(task)
+				return nil
+			}}},
+		}},
+		ProgressInterval: time.Hour,
+	}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "migration", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if afterStep != nil {
+		t.Errorf("Expected the progress to be cleared by the next step, got %+v", afterStep)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone {
+		t.Errorf("Expected the task to be done, got %s", task.Status)
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	if p := (&Progress{Current: 63, Total: 100}); p.Percent() != 63 {
+		t.Errorf("Expected 63%%, got %f", p.Percent())
+	}
+	if p := (&Progress{Current: 3}); p.Percent() != 0 {
+		t.Errorf("Expected 0%% without total, got %f", p.Percent())
+	}
+}
@@ -0,0 +1,242 @@
+package tasker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the histogram buckets, in seconds, used by
+// PrometheusMetrics
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// PrometheusMetrics implements Metrics and serves them in the Prometheus text
+// exposition format
+type PrometheusMetrics struct {
+	mu      sync.Mutex
+	buckets []float64
+	metrics []*promMetric
+	byName  map[string]*promMetric
+}
+
+type promMetric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	labels []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewPrometheusMetrics returns a PrometheusMetrics using the given histogram
+// buckets, or DefaultDurationBuckets when none are given
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	p := &PrometheusMetrics{
+		buckets: buckets,
+		byName:  map[string]*promMetric{},
+	}
+	p.register("tasker_tasks_enqueued_total", "counter", "Number of tasks enqueued.", "task")
+	p.register("tasker_tasks_started_total", "counter", "Number of task executions started.", "task")
+	p.register("tasker_tasks_succeeded_total", "counter", "Number of tasks which reached their last step.", "task")
+	p.register("tasker_tasks_failed_total", "counter", "Number of tasks which reached their max retry.", "task")
+	p.register("tasker_tasks_retried_total", "counter", "Number of step failures scheduled for retry.", "task")
+	p.register("tasker_step_duration_seconds", "histogram", "Duration of step executions.", "task", "step")
+	p.register("tasker_queue_depth", "gauge", "Number of tasks per status.", "status")
+	p.register("tasker_queue_oldest_due_age_seconds", "gauge", "Age of the oldest todo date per status.", "status")
+	p.register("tasker_workers_busy", "gauge", "Number of workers executing a task.")
+	p.register("tasker_workers", "gauge", "Number of workers.")
+	p.register("tasker_poll_duration_seconds", "histogram", "Duration of the queries fetching due tasks.")
+	p.register("tasker_claim_duration_seconds", "histogram", "Duration of the queries claiming a task.")
+	return p
+}
+
+func (p *PrometheusMetrics) register(name, kind, help string, labels ...string) {
+	m := &promMetric{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*promSeries{},
+	}
+	p.metrics = append(p.metrics, m)
+	p.byName[name] = m
+}
+
+func (p *PrometheusMetrics) series(name string, labels ...string) *promSeries {
+	m := p.byName[name]
+	key := strings.Join(labels, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &promSeries{labels: labels}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(p.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (p *PrometheusMetrics) add(name string, v float64, labels ...string) {
+	p.mu.Lock()
+	p.series(name, labels...).value += v
+	p.mu.Unlock()
+}
+
+func (p *PrometheusMetrics) set(name string, v float64, labels ...string) {
+	p.mu.Lock()
+	p.series(name, labels...).value = v
+	p.mu.Unlock()
+}
+
+func (p *PrometheusMetrics) observe(name string, v float64, labels ...string) {
+	p.mu.Lock()
+	s := p.series(name, labels...)
+	for i, b := range p.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	p.mu.Unlock()
+}
+
+// TaskEnqueued implements Metrics
+func (p *PrometheusMetrics) TaskEnqueued(task string) {
+	p.add("tasker_tasks_enqueued_total", 1, task)
+}
+
+// TaskStarted implements Metrics
+func (p *PrometheusMetrics) TaskStarted(task string) {
+	p.add("tasker_tasks_started_total", 1, task)
+}
+
+// TaskSucceeded implements Metrics
+func (p *PrometheusMetrics) TaskSucceeded(task string) {
+	p.add("tasker_tasks_succeeded_total", 1, task)
+}
+
+// TaskFailed implements Metrics
+func (p *PrometheusMetrics) TaskFailed(task string) {
+	p.add("tasker_tasks_failed_total", 1, task)
+}
+
+// TaskRetried implements Metrics
+func (p *PrometheusMetrics) TaskRetried(task string) {
+	p.add("tasker_tasks_retried_total", 1, task)
+}
+
+// StepDuration implements Metrics
+func (p *PrometheusMetrics) StepDuration(task, step string, d time.Duration) {
+	p.observe("tasker_step_duration_seconds", d.Seconds(), task, step)
+}
+
+// QueueDepth implements Metrics
+func (p *PrometheusMetrics) QueueDepth(status string, depth int64, oldestDueAge time.Duration) {
+	p.set("tasker_queue_depth", float64(depth), status)
+	p.set("tasker_queue_oldest_due_age_seconds", oldestDueAge.Seconds(), status)
+}
+
+// WorkersBusy implements Metrics
+func (p *PrometheusMetrics) WorkersBusy(busy, total int) {
+	p.set("tasker_workers_busy", float64(busy))
+	p.set("tasker_workers", float64(total))
+}
+
+// PollDuration implements Metrics
+func (p *PrometheusMetrics) PollDuration(d time.Duration) {
+	p.observe("tasker_poll_duration_seconds", d.Seconds())
+}
+
+// ClaimDuration implements Metrics
+func (p *PrometheusMetrics) ClaimDuration(d time.Duration) {
+	p.observe("tasker_claim_duration_seconds", d.Seconds())
+}
+
+// ServeHTTP serves the metrics for a Prometheus scrape
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range p.metrics {
+		if len(m.series) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+
+		keys := make([]string, 0, len(m.series))
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := m.series[k]
+			if m.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels), formatFloat(s.value))
+				continue
+			}
+
+			for i, bound := range p.buckets {
+				labels := formatLabels(withLabel(m.labels, "le"), withLabel(s.labels, formatFloat(bound)))
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, labels, s.counts[i])
+			}
+			labels := formatLabels(withLabel(m.labels, "le"), withLabel(s.labels, "+Inf"))
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, labels, s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels), formatFloat(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels), s.count)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels []string, label string) []string {
+	return append(append([]string{}, labels...), label)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,48 @@
+package tasker
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	p := NewPrometheusMetrics(0.1, 1)
+	p.TaskEnqueued("test")
+	p.TaskEnqueued("test")
+	p.TaskEnqueued(`we"ird`)
+	p.StepDuration("test", "step1", 50*time.Millisecond)
+	p.StepDuration("test", "step1", 2*time.Second)
+	p.QueueDepth("todo", 12, 90*time.Second)
+	p.WorkersBusy(1, 4)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	expected := []string{
+		"# TYPE tasker_tasks_enqueued_total counter",
+		`tasker_tasks_enqueued_total{task="test"} 2`,
+		`tasker_tasks_enqueued_total{task="we\"ird"} 1`,
+		"# TYPE tasker_step_duration_seconds histogram",
+		`tasker_step_duration_seconds_bucket{task="test",step="step1",le="0.1"} 1`,
+		`tasker_step_duration_seconds_bucket{task="test",step="step1",le="1"} 1`,
+		`tasker_step_duration_seconds_bucket{task="test",step="step1",le="+Inf"} 2`,
+		`tasker_step_duration_seconds_sum{task="test",step="step1"} 2.05`,
+		`tasker_step_duration_seconds_count{task="test",step="step1"} 2`,
+		`tasker_queue_depth{status="todo"} 12`,
+		`tasker_queue_oldest_due_age_seconds{status="todo"} 90`,
+		"tasker_workers_busy 1",
+		"tasker_workers 4",
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e+"\n") {
+			t.Errorf("Missing %q in:\n%s", e, body)
+		}
+	}
+
+	if strings.Contains(body, "tasker_tasks_failed_total") {
+		t.Errorf("Metrics without any sample should not be exposed")
+	}
+}
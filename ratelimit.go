@@ -0,0 +1,114 @@
+package tasker
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the number of tasks of a name claimed per interval, the
+// tasks over the limit stay in the queue until an interval with tokens left.
+// Every execution takes a token, retries included.
+type RateLimit struct {
+	// Tokens is the number of claims allowed per interval
+	Tokens int
+	// Interval must be positive, intervals are aligned on the Unix epoch so
+	// that replicas agree on them
+	Interval time.Duration
+	// Distributed shares the tokens between the schedulers using the same
+	// store, each scheduler has its own tokens otherwise
+	Distributed bool
+}
+
+// takeTokens returns the tokens used after taking up to n of the max tokens
+// and the number taken, a negative n gives tokens back
+func takeTokens(used, max, n int) (int, int) {
+	if n >= 0 {
+		if n > max-used {
+			n = max - used
+		}
+		if n < 0 {
+			n = 0
+		}
+	} else if -n > used {
+		n = -used
+	}
+	return used + n, n
+}
+
+type rateWindow struct {
+	start time.Time
+	used  int
+}
+
+// rateWindows holds the tokens used in the current window of every name,
+// the zero value is ready to use
+type rateWindows struct {
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+func (r *rateWindows) use(name string, window time.Time, max, n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.windows == nil {
+		r.windows = map[string]*rateWindow{}
+	}
+	w, ok := r.windows[name]
+	if !ok || !w.start.Equal(window) {
+		w = &rateWindow{start: window}
+		r.windows[name] = w
+	}
+
+	var taken int
+	w.used, taken = takeTokens(w.used, max, n)
+	return taken
+}
+
+// useTokens implements Store.UseTokens for the SQL stores, forUpdate locks
+// the row of the name when the database supports it
+func useTokens(ctx context.Context, db *sql.DB, table, forUpdate string, name string, window time.Time, max, n int) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Inserting first takes the write lock of SQLite before the read
+	start := window.UnixNano()
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+table+` ("name", "window_start", "used") VALUES ($1, $2, 0)
+		ON CONFLICT ("name") DO NOTHING`, name, start)
+	if err != nil {
+		return 0, err
+	}
+
+	var savedStart int64
+	var used int
+	err = tx.QueryRowContext(ctx, `SELECT "window_start", "used" FROM `+table+` WHERE "name"=$1`+forUpdate, name).
+		Scan(&savedStart, &used)
+	if err != nil {
+		return 0, err
+	}
+	if savedStart != start {
+		used = 0
+	}
+
+	used, taken := takeTokens(used, max, n)
+	_, err = tx.ExecContext(ctx, `UPDATE `+table+` SET "window_start"=$1, "used"=$2 WHERE "name"=$3`, start, used, name)
+	if err != nil {
+		return 0, err
+	}
+	return taken, tx.Commit()
+}
+
+// useTokens takes up to n tokens of the rate limit of a task, a negative n
+// gives tokens back
+func (s *Scheduler) useTokens(name string, rate *RateLimit, n int) (int, error) {
+	window := time.Now().Truncate(rate.Interval)
+	if rate.Distributed {
+		return s.store().UseTokens(s.context(), name, window, rate.Tokens, n)
+	}
+	return s.tokens.use(name, window, rate.Tokens, n), nil
+}
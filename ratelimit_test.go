@@ -0,0 +1,110 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestTakeTokens(t *testing.T) {
+	cases := []struct{ used, max, n, expectedUsed, expectedTaken int }{
+		{0, 3, 2, 2, 2},
+		{2, 3, 2, 3, 1},
+		{3, 3, 1, 3, 0},
+		{3, 3, -1, 2, -1},
+		{1, 3, -2, 0, -1},
+		{5, 3, 1, 5, 0},
+	}
+	for _, c := range cases {
+		used, taken := takeTokens(c.used, c.max, c.n)
+		if used != c.expectedUsed || taken != c.expectedTaken {
+			t.Errorf("takeTokens(%d, %d, %d) = %d, %d, expected %d, %d",
+				c.used, c.max, c.n, used, taken, c.expectedUsed, c.expectedTaken)
+		}
+	}
+}
+
+func TestSchedulerRateLimit(t *testing.T) {
+	for _, distributed := range []bool{false, true} {
+		useMemoryStore(t)
+
+		s := &Scheduler{
+			Tasks: []Task{{
+				Name:      "limited",
+				Steps:     []Step{{Name: "step1", Exec: testStep}},
+				RateLimit: &RateLimit{Tokens: 2, Interval: time.Hour, Distributed: distributed},
+			}, {
+				Name:  "unlimited",
+				Steps: []Step{{Name: "step1", Exec: testStep}},
+			}},
+		}
+
+		var params []EnqueueParams
+		for i := 0; i < 3; i++ {
+			params = append(params,
+				EnqueueParams{Name: "limited", TodoDate: time.Now().Add(-time.Second)},
+				EnqueueParams{Name: "unlimited", TodoDate: time.Now().Add(-time.Second)})
+		}
+		_, err := s.EnqueueMany(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := s.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 5 {
+			t.Errorf("Expected 5 executed tasks, got %d", n)
+		}
+		n, err = s.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("Expected the limited task to be deferred, got %d executed tasks", n)
+		}
+
+		tasks, err := ListTasks(ctx, TaskFilter{Name: "limited", Status: m.TaskStatusTodo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != 1 {
+			t.Errorf("Expected 1 deferred task, got %d", len(tasks))
+		}
+	}
+}
+
+func TestSchedulerRateLimitUnusedTokens(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:      "limited",
+			Steps:     []Step{{Name: "step1", Exec: testStep}},
+			RateLimit: &RateLimit{Tokens: 2, Interval: time.Hour},
+		}},
+	}
+
+	// Tokens are given back when there is nothing to claim
+	n, err := s.ExecOnce()
+	if err != nil || n != 0 {
+		t.Fatalf("Expected no task, got %d, %v", n, err)
+	}
+
+	_, err = s.EnqueueMany(ctx, []EnqueueParams{
+		{Name: "limited", TodoDate: time.Now().Add(-time.Second)},
+		{Name: "limited", TodoDate: time.Now().Add(-time.Second)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 executed tasks, got %d", n)
+	}
+}
@@ -0,0 +1,27 @@
+package tasker
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error of a step which panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// safeExec runs h, a panic is converted to a PanicError holding the stack of
+// the panicking goroutine
+func safeExec(h StepHandler, t *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return h(t)
+}
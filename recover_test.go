@@ -0,0 +1,135 @@
+package tasker
+
+import (
+	"strings"
+	"testing"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func testPanickingStep(t *Task) error {
+	var b *Buffer
+	b.NodeID = "boom"
+	return nil
+}
+
+func TestStepPanic(t *testing.T) {
+	useMemoryStore(t)
+	var stepErr error
+	task := &Task{
+		Name:     "test",
+		MaxRetry: 2,
+		UserTask: &UserTask{Task: &m.Task{
+			ID:     "c9f51923-293a-4e3b-a49f-cccd71db4679",
+			Status: m.TaskStatusTodo,
+		}},
+		Steps: []Step{{Name: "step1", Exec: testPanickingStep}},
+		Hooks: Hooks{
+			OnStepFailure: func(t *Task, step string, err error) { stepErr = err },
+		},
+	}
+
+	err := task.Exec()
+	if err != nil {
+		t.Fatalf("Panic should be handled as a step failure, got %v", err)
+	}
+	if task.UserTask.Retry != 1 {
+		t.Errorf("Panic should count as a retry")
+	}
+
+	perr, ok := stepErr.(*PanicError)
+	if !ok {
+		t.Fatalf("Expected a PanicError, got %v", stepErr)
+	}
+	if !strings.Contains(perr.Error(), "nil pointer dereference") || !strings.Contains(perr.Error(), "testPanickingStep") {
+		t.Errorf("Error should hold the panic value and stack trace: %s", perr.Error())
+	}
+
+	attempts, err := GetAttempts(ctx, task.UserTask.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || !strings.Contains(attempts[0].Error.String, "testPanickingStep") {
+		t.Errorf("The stack trace should be stored with the attempt: %+v", attempts)
+	}
+
+	err = task.Exec()
+	if err != ErrReachedMaxRetry {
+		t.Errorf("Expected ErrReachedMaxRetry, got %v", err)
+	}
+}
+
+func TestTaskMiddlewarePanic(t *testing.T) {
+	useMemoryStore(t)
+	task := &Task{
+		Name:     "test",
+		MaxRetry: 1,
+		UserTask: &UserTask{Task: &m.Task{
+			ID:     "c9f51923-293a-4e3b-a49f-cccd71db4679",
+			Status: m.TaskStatusTodo,
+		}},
+		Steps: []Step{{Name: "step1", Exec: testStep}},
+		TaskMiddlewares: []StepMiddleware{func(next StepHandler) StepHandler {
+			return func(t *Task) error {
+				panic("middleware")
+			}
+		}},
+	}
+
+	err := task.Exec()
+	if err != ErrReachedMaxRetry {
+		t.Errorf("Expected ErrReachedMaxRetry, got %v", err)
+	}
+}
+
+func TestTaskMiddlewarePanicAfterSteps(t *testing.T) {
+	useMemoryStore(t)
+	task := &Task{
+		Name:     "test",
+		MaxRetry: 1,
+		UserTask: &UserTask{Task: &m.Task{
+			ID:     "c9f51923-293a-4e3b-a49f-cccd71db4679",
+			Status: m.TaskStatusTodo,
+		}},
+		Steps: []Step{{Name: "step1", Exec: testStep}},
+		TaskMiddlewares: []StepMiddleware{func(next StepHandler) StepHandler {
+			return func(t *Task) error {
+				next(t)
+				panic("middleware")
+			}
+		}},
+	}
+
+	err := task.Exec()
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if task.UserTask.Status != m.TaskStatusDone || task.UserTask.Retry != 0 {
+		t.Errorf("The task should stay done, got %s with retry %d", task.UserTask.Status, task.UserTask.Retry)
+	}
+}
+
+func TestHookPanic(t *testing.T) {
+	useMemoryStore(t)
+	task := &Task{
+		Name:     "test",
+		MaxRetry: 1,
+		UserTask: &UserTask{Task: &m.Task{
+			ID:     "c9f51923-293a-4e3b-a49f-cccd71db4679",
+			Status: m.TaskStatusTodo,
+		}},
+		Steps: []Step{{Name: "step1", Exec: testStep}},
+		Hooks: Hooks{
+			OnStart: func(t *Task) { panic("start") },
+			OnDone:  func(t *Task) { panic("done") },
+		},
+	}
+
+	err := task.Exec()
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if task.UserTask.Status != m.TaskStatusDone {
+		t.Errorf("A panicking hook should not fail the task, got %s", task.UserTask.Status)
+	}
+}
@@ -0,0 +1,290 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// newerVersionDelay postpones a task claimed by a scheduler which doesn't
+// know its version yet, like during a rolling deploy
+const newerVersionDelay = 10 * time.Second
+
+// DefinitionError is returned for an invalid task definition, it names the
+// offending task and step
+type DefinitionError struct {
+	Task    string
+	Version int
+	// Step is the name of the invalid step, Index its position in the steps
+	// of the task or -1 when the step is not one of them
+	Step  string
+	Index int
+	Err   error
+}
+
+func (e *DefinitionError) Error() string {
+	task := fmt.Sprintf("task %q", e.Task)
+	if e.Version != 0 {
+		task += fmt.Sprintf(" version %d", e.Version)
+	}
+	switch {
+	case e.Index >= 0:
+		return fmt.Sprintf("%s step %d %q: %s", task, e.Index, e.Step, e.Err.Error())
+	case e.Step != "":
+		return fmt.Sprintf("%s step %q: %s", task, e.Step, e.Err.Error())
+	}
+	return task + ": " + e.Err.Error()
+}
+
+// Unwrap returns the typed error, for errors.Is
+func (e *DefinitionError) Unwrap() error {
+	return e.Err
+}
+
+// Register validates the task definitions and adds them to the ones executed
+// by the scheduler, nothing is added when one of them is invalid. It must be
+// called before Exec. The tasks of the Tasks field are registered first.
+//
+// Several versions of a task can be registered, the tasks are enqueued with
+// the latest one and each task runs on the version it was enqueued with.
+func (s *Scheduler) Register(tasks ...Task) error {
+	_, _, err := s.definitions()
+	if err != nil {
+		return err
+	}
+	return s.register(tasks)
+}
+
+// definitions returns the latest version of the registered tasks by name and
+// their names in registration order, the Tasks field is registered on the
+// first call
+func (s *Scheduler) definitions() (map[string]Task, []string, error) {
+	if s.registry == nil {
+		s.registry = map[string]Task{}
+		s.versions = map[string][]Task{}
+		err := s.register(s.Tasks)
+		if err != nil {
+			s.registry = nil
+			s.versions = nil
+			return nil, nil, err
+		}
+	}
+	return s.registry, s.names, nil
+}
+
+func (s *Scheduler) register(tasks []Task) error {
+	seen := map[string]bool{}
+	for _, t := range tasks {
+		err := validateDefinition(t)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s/%d", t.Name, t.Version)
+		if _, ok := s.version(t.Name, t.Version); ok || seen[key] {
+			return &DefinitionError{Task: t.Name, Version: t.Version, Index: -1, Err: ErrDuplicateTaskName}
+		}
+		seen[key] = true
+	}
+
+	for _, t := range tasks {
+		if _, ok := s.registry[t.Name]; !ok {
+			s.names = append(s.names, t.Name)
+		}
+		versions := append(s.versions[t.Name], t)
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i].Version < versions[j].Version
+		})
+		s.versions[t.Name] = versions
+		s.registry[t.Name] = versions[len(versions)-1]
+	}
+	return nil
+}
+
+// version returns a registered version of a task
+func (s *Scheduler) version(name string, version int) (Task, bool) {
+	for _, def := range s.versions[name] {
+		if def.Version == version {
+			return def, true
+		}
+	}
+	return Task{}, false
+}
+
+// definition returns the definition executing a claimed task. A task whose
+// version is not registered anymore is moved to the latest version, its
+// actual step renamed by the newer versions. It is false for a task of a
+// version newer than the registered ones.
+func (s *Scheduler) definition(task *m.Task) (Task, bool) {
+	def, step, ok := s.resolve(task.Name, task.Version, task.ActualStep)
+	if !ok || def.Version == task.Version {
+		return def, ok
+	}
+
+	fmt.Printf("Migrating task %s from version %d to %d\n", task.ID, task.Version, def.Version)
+	task.ActualStep = step
+	task.Version = def.Version
+	return def, true
+}
+
+// resolve returns the definition of a version of a task and the step a task
+// at the given step resumes at, see definition
+func (s *Scheduler) resolve(name string, version int, step string) (Task, string, bool) {
+	if version == 0 && step == "" {
+		// Not started and enqueued without a scheduler stamping the version
+		latest, ok := s.registry[name]
+		return latest, step, ok
+	}
+	if def, ok := s.version(name, version); ok {
+		return def, step, true
+	}
+
+	latest, ok := s.registry[name]
+	if !ok || version > latest.Version {
+		return latest, step, false
+	}
+	for _, def := range s.versions[name] {
+		if renamed, ok := def.Renames[step]; ok && def.Version > version {
+			step = renamed
+		}
+	}
+	return latest, step, true
+}
+
+// MoveTask puts a pending or failed task back in the queue at the given step
+// of its definition, a task of a retired version is moved to the latest one
+func (s *Scheduler) MoveTask(ctx context.Context, id, step string) (*m.Task, error) {
+	_, _, err := s.definitions()
+	if err != nil {
+		return nil, err
+	}
+	task, err := s.store().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.moveToStep(ctx, s.store(), task, step)
+}
+
+// moveToStep moves a task of the store to a step of its definition, in the
+// queue of the step
+func (s *Scheduler) moveToStep(ctx context.Context, store Store, task *m.Task, name string) (*m.Task, error) {
+	def, ok := s.definition(task)
+	if !ok {
+		return nil, &DefinitionError{Task: task.Name, Version: task.Version, Step: name, Index: -1, Err: ErrTaskNotRegistered}
+	}
+	step, ok := findStep(def, name)
+	if !ok {
+		return nil, &DefinitionError{Task: task.Name, Version: task.Version, Step: name, Index: -1, Err: ErrStepNotFound}
+	}
+	return moveTask(ctx, store, task, step)
+}
+
+// OrphanedSteps returns the number of pending tasks per actual step which is
+// not a step of their definition, the tasks of unregistered names and newer
+// versions are ignored. Such tasks end in error when executed, move them to
+// another step with MoveTask or add a rename to their definition.
+func (s *Scheduler) OrphanedSteps(ctx context.Context) ([]StepCount, error) {
+	_, _, err := s.definitions()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.store().PendingSteps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphaned []StepCount
+	for _, c := range counts {
+		def, step, ok := s.resolve(c.Name, c.Version, c.Step)
+		if !ok || step == "" {
+			continue
+		}
+		if _, found := findStep(def, step); !found {
+			orphaned = append(orphaned, c)
+		}
+	}
+	return orphaned, nil
+}
+
+// reportOrphanedSteps prints the pending tasks which will fail because their
+// step does not exist
+func (s *Scheduler) reportOrphanedSteps() error {
+	orphaned, err := s.OrphanedSteps(s.context())
+	if err != nil {
+		return err
+	}
+	for _, c := range orphaned {
+		fmt.Printf("%d pending tasks %s version %d are at the unknown step %s\n", c.Count, c.Name, c.Version, c.Step)
+	}
+	return nil
+}
+
+func findStep(def Task, name string) (Step, bool) {
+	for _, step := range def.Steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return Step{}, false
+}
+
+// stampDefinitions sets the latest registered version of the tasks enqueued
+// without version, and the queue of the first step of their version
+func (s *Scheduler) stampDefinitions(params []EnqueueParams) []EnqueueParams {
+	defs, _, _ := s.definitions()
+	stamped := make([]EnqueueParams, len(params))
+	for i, p := range params {
+		if p.Version == 0 {
+			p.Version = defs[p.Name].Version
+		}
+		if def, ok := s.version(p.Name, p.Version); ok {
+			p.queue = def.Steps[0].Queue
+		}
+		stamped[i] = p
+	}
+	return stamped
+}
+
+// validateDefinition checks a task definition before it can be executed
+func validateDefinition(t Task) error {
+	if t.Name == "" {
+		return &DefinitionError{Version: t.Version, Index: -1, Err: ErrMissingTaskName}
+	}
+	if len(t.Steps) == 0 {
+		return &DefinitionError{Task: t.Name, Version: t.Version, Index: -1, Err: ErrMissingSteps}
+	}
+	if t.RateLimit != nil && (t.RateLimit.Tokens <= 0 || t.RateLimit.Interval <= 0) {
+		return &DefinitionError{Task: t.Name, Version: t.Version, Index: -1, Err: ErrInvalidRateLimit}
+	}
+	if t.Concurrency != nil && t.Concurrency.Max < 0 {
+		return &DefinitionError{Task: t.Name, Version: t.Version, Index: -1, Err: ErrInvalidConcurrency}
+	}
+	if t.Concurrency != nil && t.Concurrency.Key == "" {
+		return &DefinitionError{Task: t.Name, Version: t.Version, Index: -1, Err: ErrMissingConcurrency}
+	}
+
+	seen := map[string]bool{}
+	for i, step := range t.Steps {
+		stepErr := func(err error) error {
+			return &DefinitionError{Task: t.Name, Version: t.Version, Step: step.Name, Index: i, Err: err}
+		}
+		switch {
+		case step.Name == "":
+			return stepErr(ErrMissingStepName)
+		case seen[step.Name]:
+			return stepErr(ErrDuplicateStepName)
+		case step.Exec == nil:
+			return stepErr(ErrMissingExecFunction)
+		}
+		seen[step.Name] = true
+	}
+
+	for _, to := range t.Renames {
+		if !seen[to] {
+			return &DefinitionError{Task: t.Name, Version: t.Version, Step: to, Index: -1, Err: ErrStepNotFound}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestRegister(t *testing.T) {
+	noop := func(t *Task) error { return nil }
+
+	cases := []struct {
+		task    Task
+		err     error
+		message string
+	}{
+		{Task{Steps: []Step{{Name: "step1", Exec: noop}}}, ErrMissingTaskName, `task "": missing task name`},
+		{Task{Name: "empty"}, ErrMissingSteps, `task "empty": missing steps in task`},
+		{Task{Name: "unnamed", Steps: []Step{{Name: "step1", Exec: noop}, {Exec: noop}}}, ErrMissingStepName, `task "unnamed" step 1 "": missing step name`},
+		{Task{Name: "twice", Steps: []Step{{Name: "step1", Exec: noop}, {Name: "step1", Exec: noop}}}, ErrDuplicateStepName, `task "twice" step 1 "step1": duplicate step name`},
+		{Task{Name: "noexec", Steps: []Step{{Name: "step1"}}}, ErrMissingExecFunction, `task "noexec" step 0 "step1": missing exec function`},
+		{Task{Name: "valid", Steps: []Step{{Name: "step1", Exec: noop}}}, ErrDuplicateTaskName, `task "valid": duplicate task name`},
+		{Task{Name: "notokens", Steps: []Step{{Name: "step1", Exec: noop}}, RateLimit: &RateLimit{Interval: time.Second}},
+			ErrInvalidRateLimit, `task "notokens": rate limit needs positive tokens and interval`},
+		{Task{Name: "nointerval", Steps: []Step{{Name: "step1", Exec: noop}}, RateLimit: &RateLimit{Tokens: 1}},
+			ErrInvalidRateLimit, `task "nointerval": rate limit needs positive tokens and interval`},
+		{Task{Name: "negative", Steps: []Step{{Name: "step1", Exec: noop}}, Concurrency: &ConcurrencyLimit{Key: "user_address", Max: -1}},
+			ErrInvalidConcurrency, `task "negative": concurrency limit max cannot be negative`},
+		{Task{Name: "nokey", Steps: []Step{{Name: "step1", Exec: noop}}, Concurrency: &ConcurrencyLimit{Max: 1}},
+			ErrMissingConcurrency, `task "nokey": missing concurrency limit key`},
+	}
+
+	s := &Scheduler{Tasks: []Task{{Name: "valid", Steps: []Step{{Name: "step1", Exec: noop}}}}}
+	for _, c := range cases {
+		err := s.Register(c.task)
+		if !errors.Is(err, c.err) {
+			t.Errorf("Expected %v registering %q, got %v", c.err, c.task.Name, err)
+			continue
+		}
+		if err.Error() != c.message {
+			t.Errorf("Expected the error %s, got %s", c.message, err.Error())
+		}
+	}
+
+	err := s.Register(Task{Name: "other", Steps: []Step{{Name: "step1", Exec: noop}}}, Task{Name: "other"})
+	if !errors.Is(err, ErrMissingSteps) {
+		t.Errorf("Expected the second definition to be invalid, got %v", err)
+	}
+	err = s.Register(Task{Name: "other", Steps: []Step{{Name: "step1", Exec: noop}}})
+	if err != nil {
+		t.Errorf("Expected nothing to be registered by the invalid call, got %v", err)
+	}
+
+	defs, names, err := s.definitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 2 || len(names) != 2 || names[0] != "valid" || names[1] != "other" {
+		t.Errorf("Expected the tasks in registration order, got %v", names)
+	}
+}
+
+func TestExecOnceInvalidTasks(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{Tasks: []Task{{Name: "test", Steps: []Step{{Name: "step1"}}}}}
+	_, err := s.ExecOnce()
+	var defErr *DefinitionError
+	if !errors.As(err, &defErr) || defErr.Task != "test" || defErr.Step != "step1" {
+		t.Errorf("Expected the invalid definition to be reported, got %v", err)
+	}
+}
+
+func TestExecMissingStepName(t *testing.T) {
+	task := &Task{
+		Name:     "test",
+		UserTask: &UserTask{Task: &m.Task{}},
+		Steps:    []Step{{Exec: testStep}},
+	}
+	err := task.Exec()
+	if err != ErrMissingStepName {
+		t.Errorf("Expected ErrMissingStepName, got %v", err)
+	}
+}
+
+func TestTaskVersions(t *testing.T) {
+	useMemoryStore(t)
+
+	var order []string
+	step := func(name string) Step {
+		return Step{Name: name, Exec: func(t *Task) error {
+			order = append(order, fmt.Sprintf("v%d.%s", t.Version, name))
+			return nil
+		}}
+	}
+	v1 := Task{Name: "import", Version: 1, Steps: []Step{step("fetch"), step("store")}}
+	v2 := Task{Name: "import", Version: 2, Steps: []Step{step("fetch"), step("transform"), step("save")},
+		Renames: map[string]string{"store": "save"}}
+
+	s := &Scheduler{}
+	err := s.Register(v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Register(Task{Name: "import", Version: 1, Steps: []Step{step("fetch")}})
+	if !errors.Is(err, ErrDuplicateTaskName) || err.Error() != `task "import" version 1: duplicate task name` {
+		t.Errorf("Expected the version to be registered once, got %v", err)
+	}
+	err = s.Register(Task{Name: "import", Version: 3, Steps: []Step{step("fetch")}, Renames: map[string]string{"save": "write"}})
+	if !errors.Is(err, ErrStepNotFound) || err.Error() != `task "import" version 3 step "write": step not found` {
+		t.Errorf("Expected the rename to an unknown step to be rejected, got %v", err)
+	}
+
+	latest, err := s.Enqueue(ctx, EnqueueParams{Name: "import", TodoDate: time.Now().Add(-2 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 2 {
+		t.Errorf("Expected the task to be stamped with the latest version, got %d", latest.Version)
+	}
+	_, err = s.Enqueue(ctx, EnqueueParams{Name: "import", Version: 1, TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"v2.fetch", "v2.transform", "v2.save", "v1.fetch", "v1.store"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Errorf("Expected the versions to run side by side %v, got %v", expected, order)
+	}
+
+	// Version 1 is retired while a task waits for its last step
+	order = nil
+	old, err := s.Enqueue(ctx, EnqueueParams{Name: "import", Version: 1, TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.ActualStep = "store"
+	newer, err := s.Enqueue(ctx, EnqueueParams{Name: "import", Version: 3, TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.store().Update(ctx, []string{m.TaskColumns.ActualStep}, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = &Scheduler{Tasks: []Task{v2}}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[v2.save]" {
+		t.Errorf("Expected the task to resume at the renamed step, got %v", order)
+	}
+	old, err = GetTask(ctx, old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Status != m.TaskStatusDone || old.Version != 2 || old.ActualStep != "save" {
+		t.Errorf("Expected the task to be migrated to version 2, got %+v", old)
+	}
+	newer, err = GetTask(ctx, newer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newer.Status != m.TaskStatusTodo || !newer.TodoDate.After(time.Now()) {
+		t.Errorf("Expected the task of a newer version to be postponed, got %+v", newer)
+	}
+}
+
+func TestOrphanedStep(t *testing.T) {
+	useMemoryStore(t)
+
+	var order []string
+	step := func(name string) Step {
+		return Step{Name: name, Exec: func(t *Task) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+	dead := 0
+	s := &Scheduler{Tasks: []Task{{
+		Name:     "import",
+		Steps:    []Step{step("fetch"), step("save")},
+		MaxRetry: 3,
+		Hooks:    Hooks{OnDead: func(t *Task, err error) { dead++ }},
+	}}}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "import", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.ActualStep = "store"
+	err = s.store().Update(ctx, []string{m.TaskColumns.ActualStep}, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned, err := s.OrphanedSteps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(orphaned) != fmt.Sprint([]StepCount{{Name: "import", Step: "store", Count: 1}}) {
+		t.Errorf("Expected the task to be reported, got %+v", orphaned)
+	}
+
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WaitForResult(ctx, task.ID, nil)
+	if err == nil || err.Error() != "task "+task.ID+` failed: task "import" step "store": step not found` {
+		t.Errorf("Expected the task to fail with the missing step, got %v", err)
+	}
+	if dead != 1 || len(order) != 0 {
+		t.Errorf("Expected the task to end without retry, got %d dead hooks and steps %v", dead, order)
+	}
+
+	_, err = s.MoveTask(ctx, task.ID, "store")
+	if !errors.Is(err, ErrStepNotFound) {
+		t.Errorf("Expected the move to an unknown step to be rejected, got %v", err)
+	}
+	task, err = s.MoveTask(ctx, task.ID, "save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusTodo || task.ActualStep != "save" || task.Retry != 0 {
+		t.Errorf("Expected the task back in the queue at the save step, got %+v", task)
+	}
+	_, err = s.MoveTask(ctx, task.ID, "save")
+	if err != nil {
+		t.Errorf("Expected a pending task to be moved, got %v", err)
+	}
+
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[save]" {
+		t.Errorf("Expected the task to resume at the save step, got %v", order)
+	}
+	_, err = s.MoveTask(ctx, task.ID, "fetch")
+	if err != ErrInvalidStatus {
+		t.Errorf("Expected a done task to stay done, got %v", err)
+	}
+}
+
+func TestUnstampedTaskVersion(t *testing.T) {
+	useMemoryStore(t)
+
+	var order []string
+	step := func(name string) Step {
+		return Step{Name: name, Exec: func(t *Task) error {
+			order = append(order, fmt.Sprintf("v%d.%s", t.Version, name))
+			return nil
+		}}
+	}
+	s := &Scheduler{Tasks: []Task{
+		{Name: "import", Steps: []Step{step("fetch")}},
+		{Name: "import", Version: 1, Steps: []Step{step("fetch"), step("save")}},
+	}}
+
+	// Enqueued without the definitions, like by the API or the CLI
+	task, err := Enqueue(ctx, EnqueueParams{Name: "import", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Version != 0 {
+		t.Errorf("Expected an unstamped task, got version %d", task.Version)
+	}
+
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(order) != "[v1.fetch v1.save]" {
+		t.Errorf("Expected the task to run on the latest version, got %v", order)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone || task.Version != 1 {
+		t.Errorf("Expected the task done at version 1, got %+v", task)
+	}
+}
+
+func TestAPIMoveTask(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{Tasks: []Task{{
+		Name:  "render",
+		Steps: []Step{{Name: "prepare", Exec: testStep}, {Name: "render", Queue: "gpu", Exec: testStep}},
+	}}}
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "render"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	move := func(h http.Handler, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id+"/move", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The scheduler checks the steps against its definitions
+	if rec := move(s.APIHandler(), task.ID, `{"step":"upload"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected the API to reject an unknown step, got status %d", rec.Code)
+	}
+	if rec := move(s.APIHandler(), task.ID, `{"step":"render"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the task to be moved, got status %d %s", rec.Code, rec.Body.String())
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ActualStep != "render" || task.Queue != "gpu" {
+		t.Errorf("Expected the task in the queue of the render step, got %+v", task)
+	}
+
+	other, err := Enqueue(ctx, EnqueueParams{Name: "unregistered"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.MoveTask(ctx, other.ID, "step1")
+	if !errors.Is(err, ErrTaskNotRegistered) {
+		t.Errorf("Expected ErrTaskNotRegistered, got %v", err)
+	}
+
+	// Without a scheduler the moves must be forced
+	if rec := move(NewAPIHandler(), other.ID, `{"step":"step1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected the API to require a forced move, got status %d", rec.Code)
+	}
+	if rec := move(NewAPIHandler(), other.ID, `{"step":"step1","force":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the task to be moved, got status %d %s", rec.Code, rec.Body.String())
+	}
+	other, err = GetTask(ctx, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ActualStep != "step1" || other.Queue != "" {
+		t.Errorf("Expected the task in the default queue at step1, got %+v", other)
+	}
+}
@@ -0,0 +1,125 @@
+package tasker
+
+import (
+	"context"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// Delays between two reads of a waited task, the delay doubles from the
+// minimum up to the maximum
+const (
+	resultPollMin = 50 * time.Millisecond
+	resultPollMax = time.Second
+)
+
+// TaskError is returned by WaitForResult for a task which ended in error,
+// Message is the error of its last attempt
+type TaskError struct {
+	TaskID  string
+	Message string
+}
+
+func (e *TaskError) Error() string {
+	return "task " + e.TaskID + " failed: " + e.Message
+}
+
+// finishNotifier is implemented by the stores which can tell when a task
+// reaches a final status, WaitForResult polls less often with them
+type finishNotifier interface {
+	// notifyFinished returns a channel receiving a value when the task may
+	// have finished, stop must be called when done waiting
+	notifyFinished(id string) (finished <-chan struct{}, stop func())
+}
+
+// SetResult sets the result of the task, it is saved with the task and
+// returned by WaitForResult once the task is done
+func (u *UserTask) SetResult(v interface{}) error {
+	return u.Result.Marshal(v)
+}
+
+// WithResult adapts a step returning a value, typically the last step of a
+// task, the value becomes the result of the task
+func WithResult(exec func(t *Task) (interface{}, error)) func(t *Task) error {
+	return func(t *Task) error {
+		v, err := exec(t)
+		if err != nil {
+			return err
+		}
+		return t.UserTask.SetResult(v)
+	}
+}
+
+// WaitForResult blocks until the task is done and decodes its result in v,
+// v can be nil to only wait. A task ending in error returns a *TaskError and
+// a cancelled task ErrTaskCancelled.
+func WaitForResult(ctx context.Context, id string, v interface{}) error {
+	return waitForResult(ctx, defaultStore(), id, v)
+}
+
+// WaitForResult is WaitForResult on the scheduler store
+func (s *Scheduler) WaitForResult(ctx context.Context, id string, v interface{}) error {
+	return waitForResult(ctx, s.store(), id, v)
+}
+
+func waitForResult(ctx context.Context, store Store, id string, v interface{}) error {
+	var finished <-chan struct{}
+	if n, ok := store.(finishNotifier); ok {
+		var stop func()
+		finished, stop = n.notifyFinished(id)
+		defer stop()
+	}
+
+	delay := resultPollMin
+	for {
+		task, err := store.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		switch task.Status {
+		case m.TaskStatusDone:
+			if v == nil || !task.Result.Valid {
+				return nil
+			}
+			return task.Result.Unmarshal(v)
+		case m.TaskStatusError:
+			return taskError(ctx, store, task)
+		case m.TaskStatusCancelled:
+			return ErrTaskCancelled
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-finished:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > resultPollMax {
+			delay = resultPollMax
+		}
+	}
+}
+
+// taskError returns the error of the last failed attempt of a task
+func taskError(ctx context.Context, store Store, task *m.Task) error {
+	attempts, err := store.Attempts(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+
+	e := &TaskError{TaskID: task.ID, Message: ErrReachedMaxRetry.Error()}
+	for i := len(attempts) - 1; i >= 0; i-- {
+		if attempts[i].Error.Valid {
+			e.Message = attempts[i].Error.String
+			break
+		}
+	}
+	return e
+}
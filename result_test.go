@@ -0,0 +1,99 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestWaitForResult(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:     "report",
+			MaxRetry: 1,
+			Steps: []Step{{Name: "generate", Exec: WithResult(func(t *Task) (interface{}, error) {
+				return map[string]string{"report_id": "r-42"}, nil
+			})}},
+		}, {
+			Name:     "broken",
+			MaxRetry: 1,
+			Steps: []Step{{Name: "generate", Exec: func(t *Task) error {
+				return fmt.Errorf("no data")
+			}}},
+		}},
+	}
+
+	report, err := s.Enqueue(ctx, EnqueueParams{Name: "report", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, err := s.Enqueue(ctx, EnqueueParams{Name: "broken", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			_, err := s.ExecOnce()
+			if err != nil {
+				panic(err)
+			}
+		}
+	}()
+
+	var result struct {
+		ReportID string `json:"report_id"`
+	}
+	err = s.WaitForResult(ctx, report.ID, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ReportID != "r-42" {
+		t.Errorf("Expected report r-42, got %+v", result)
+	}
+
+	err = s.WaitForResult(ctx, broken.ID, nil)
+	taskErr, ok := err.(*TaskError)
+	if !ok || taskErr.TaskID != broken.ID || taskErr.Message != "no data" {
+		t.Errorf("Expected the error of the task, got %v", err)
+	}
+}
+
+func TestWaitForResultCancelled(t *testing.T) {
+	useMemoryStore(t)
+
+	task, err := Enqueue(ctx, EnqueueParams{Name: "report", TodoDate: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = WaitForResult(timeout, task.ID, nil)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+
+	_, err = CancelTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WaitForResult(ctx, task.ID, nil)
+	if err != ErrTaskCancelled {
+		t.Errorf("Expected ErrTaskCancelled, got %v", err)
+	}
+
+	got, err := GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != m.TaskStatusCancelled {
+		t.Errorf("Expected the task to be cancelled, got %s", got.Status)
+	}
+}
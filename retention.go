@@ -0,0 +1,95 @@
+package tasker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// retentionBatch is the number of tasks removed by a single statement, small
+// batches keep the locks on the tasks table short
+const retentionBatch = 500
+
+// retentionBatchesPerTick is the number of batches a policy removes per
+// ExecOnce, a larger backlog is removed over the next calls so the claims of
+// the scheduler are not delayed
+const retentionBatchesPerTick = 20
+
+// RetentionPolicy removes the tasks which stayed in a final status for too
+// long, counted from the date they reached it
+type RetentionPolicy struct {
+	Status string
+	After  time.Duration
+	// Archive moves the tasks to the archive table instead of deleting them,
+	// their attempt history is deleted either way
+	Archive bool
+}
+
+// DefaultRetentionInterval is the delay between two retention runs of a
+// scheduler when RetentionInterval is 0
+const DefaultRetentionInterval = time.Hour
+
+// archiveColumns are the columns copied to the archive table
+func archiveColumns() string {
+	columns := append([]string{m.TaskColumns.ID, m.TaskColumns.CreatedAt}, taskColumns...)
+	return `"` + strings.Join(columns, `", "`) + `"`
+}
+
+func (s *Scheduler) retentionInterval() time.Duration {
+	if s.RetentionInterval <= 0 {
+		return DefaultRetentionInterval
+	}
+	return s.RetentionInterval
+}
+
+// applyRetention runs the retention policies when the interval elapsed, they
+// run again on the next call until their backlog is removed
+func (s *Scheduler) applyRetention() {
+	if len(s.Retention) == 0 || time.Since(s.retentionAt) < s.retentionInterval() {
+		return
+	}
+
+	done := true
+	for _, p := range s.Retention {
+		n, more, err := purgeBatches(s.context(), s.store(), p, retentionBatchesPerTick)
+		if n > 0 {
+			fmt.Printf("Removed %d %s tasks\n", n, p.Status)
+		}
+		if err != nil {
+			fmt.Println("tasker: cannot apply retention:", err.Error())
+		}
+		done = done && !more
+	}
+	if done {
+		s.retentionAt = time.Now()
+	}
+}
+
+// ApplyRetention removes the tasks matched by the policy by batches and
+// returns their number
+func ApplyRetention(ctx context.Context, store Store, p RetentionPolicy) (int, error) {
+	n, _, err := purgeBatches(ctx, store, p, 0)
+	return n, err
+}
+
+// purgeBatches removes at most max batches of the tasks matched by the
+// policy, all of them when max is 0. It reports whether tasks may remain.
+func purgeBatches(ctx context.Context, store Store, p RetentionPolicy, max int) (int, bool, error) {
+	if p.Status != m.TaskStatusDone && p.Status != m.TaskStatusError && p.Status != m.TaskStatusCancelled {
+		return 0, false, ErrInvalidStatus
+	}
+
+	before := time.Now().Add(-p.After)
+	total := 0
+	for i := 0; max == 0 || i < max; i++ {
+		n, err := store.Purge(ctx, p.Status, before, p.Archive, retentionBatch)
+		total += n
+		if err != nil || n < retentionBatch {
+			return total, false, err
+		}
+	}
+	return total, true, nil
+}
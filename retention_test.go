@@ -0,0 +1,148 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestSchedulerRetention(t *testing.T) {
+	store := useMemoryStore(t)
+
+	var tasks []*m.Task
+	for i := 0; i < retentionBatch+1; i++ {
+		tasks = append(tasks, &m.Task{Name: "test", Status: m.TaskStatusDone, FinishedAt: null.TimeFrom(time.Now().Add(-8 * 24 * time.Hour))})
+	}
+	tasks = append(tasks,
+		// Scheduled long ago, the task only finished recently
+		&m.Task{Name: "test", Status: m.TaskStatusDone, TodoDate: time.Now().Add(-8 * 24 * time.Hour), FinishedAt: null.TimeFrom(time.Now().Add(-time.Hour))},
+		&m.Task{Name: "test", Status: m.TaskStatusError, FinishedAt: null.TimeFrom(time.Now().Add(-31 * 24 * time.Hour))})
+	err := store.Enqueue(ctx, tasks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scheduler{
+		Retention: []RetentionPolicy{
+			{Status: m.TaskStatusDone, After: 7 * 24 * time.Hour},
+			{Status: m.TaskStatusError, After: 30 * 24 * time.Hour, Archive: true},
+		},
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left, err := ListTasks(ctx, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].ID != tasks[retentionBatch+1].ID {
+		t.Errorf("Expected only the recent task to be kept, got %d tasks", len(left))
+	}
+	if len(store.archive) != 1 || store.archive[0].ID != tasks[retentionBatch+2].ID {
+		t.Errorf("Expected the error task to be archived, got %+v", store.archive)
+	}
+
+	// The policies don't run again before the interval
+	err = store.Enqueue(ctx, &m.Task{Name: "test", Status: m.TaskStatusDone, FinishedAt: null.TimeFrom(time.Now().Add(-8 * 24 * time.Hour))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, err = ListTasks(ctx, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 {
+		t.Errorf("Expected the retention to wait for the interval, got %d tasks", len(left))
+	}
+}
+
+func TestRetentionBatchesPerTick(t *testing.T) {
+	store := NewMemoryStore()
+	var tasks []*m.Task
+	for i := 0; i < retentionBatch+1; i++ {
+		tasks = append(tasks, &m.Task{Name: "test", Status: m.TaskStatusDone, FinishedAt: null.TimeFrom(time.Now().Add(-2 * time.Hour))})
+	}
+	err := store.Enqueue(ctx, tasks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := RetentionPolicy{Status: m.TaskStatusDone, After: time.Hour}
+	n, more, err := purgeBatches(ctx, store, p, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != retentionBatch || !more {
+		t.Errorf("Expected a single batch with tasks left, removed %d", n)
+	}
+	n, more, err = purgeBatches(ctx, store, p, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || more {
+		t.Errorf("Expected the last task to be removed, removed %d", n)
+	}
+}
+
+func TestApplyRetentionInvalidStatus(t *testing.T) {
+	_, err := ApplyRetention(ctx, NewMemoryStore(), RetentionPolicy{Status: m.TaskStatusTodo, After: time.Hour})
+	if err != ErrInvalidStatus {
+		t.Errorf("Expected ErrInvalidStatus, got %v", err)
+	}
+}
+
+func TestFinishedAt(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{Tasks: []Task{{Name: "test", Steps: []Step{{Name: "step1", Exec: testStep}}}}}
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "test", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.FinishedAt.Valid {
+		t.Errorf("A pending task should not be finished")
+	}
+	_, err = s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone || !task.FinishedAt.Valid {
+		t.Errorf("Expected the date the task finished, got %+v", task)
+	}
+
+	task, err = s.Enqueue(ctx, EnqueueParams{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = CancelTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !task.FinishedAt.Valid {
+		t.Errorf("Expected the date the task was cancelled")
+	}
+	task, err = RetryTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.FinishedAt.Valid {
+		t.Errorf("A retried task should not be finished")
+	}
+}
@@ -0,0 +1,647 @@
+package tasker
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// sqliteTimeFormat is how the timestamps are compared and stored, always in
+// UTC so that they sort as text
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// SQLiteStore is a Store backed by SQLite, the driver is not imported by
+// tasker so it must be registered by the program (github.com/mattn/go-sqlite3
+// for instance). The schema is created by MigrateSQLite.
+type SQLiteStore struct {
+	// Tables can be changed before the store is used, the default tables are
+	// used when empty
+	Tables Tables
+
+	db *sql.DB
+}
+
+// NewSQLiteStore returns a Store using the given connection
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+func (s *SQLiteStore) tasks() string {
+	return quoteIdent(s.Tables.withDefaults().Tasks)
+}
+
+func (s *SQLiteStore) attempts() string {
+	return quoteIdent(s.Tables.withDefaults().Attempts)
+}
+
+func (s *SQLiteStore) archive() string {
+	return quoteIdent(s.Tables.withDefaults().Archive)
+}
+
+func (s *SQLiteStore) rateLimits() string {
+	return quoteIdent(s.Tables.withDefaults().RateLimits)
+}
+
+// Migrate applies the migrations of the store tables, see MigrateSQLite
+func (s *SQLiteStore) Migrate(ctx context.Context) error {
+	return migrateSQLite(ctx, s.db, s.Tables.withDefaults())
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// store tables
+func (s *SQLiteStore) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, s.db, quoteIdent(s.Tables.withDefaults().Migrations))
+}
+
+// sqliteValues converts the timestamps to UTC
+func sqliteValues(values []interface{}) []interface{} {
+	for i, v := range values {
+		switch t := v.(type) {
+		case time.Time:
+			values[i] = t.UTC()
+		case null.Time:
+			if t.Valid {
+				values[i] = t.Time.UTC()
+			}
+		}
+	}
+	return values
+}
+
+// placeholders returns n comma separated placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// sqliteEnqueueBatch is the number of tasks inserted by a single statement,
+// it keeps the number of parameters under the SQLite limit
+const sqliteEnqueueBatch = 500
+
+// Enqueue implements Store, the tasks are inserted with multi-row inserts in
+// a single transaction
+func (s *SQLiteStore) Enqueue(ctx context.Context, tasks ...*m.Task) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(tasks); start += sqliteEnqueueBatch {
+		end := start + sqliteEnqueueBatch
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		err = s.enqueueBatch(ctx, tx, tasks[start:end])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) enqueueBatch(ctx context.Context, tx *sql.Tx, tasks []*m.Task) error {
+	columns := append([]string{m.TaskColumns.ID, m.TaskColumns.CreatedAt}, taskColumns...)
+	rows := make([]string, 0, len(tasks))
+	args := make([]interface{}, 0, len(tasks)*len(columns))
+	for _, task := range tasks {
+		err := setEnqueueDefaults(task)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, "("+placeholders(len(columns))+")")
+		args = append(args, task.ID, task.CreatedAt)
+		args = append(args, taskValues(task)...)
+	}
+
+	result, err := tx.QueryContext(ctx, `INSERT INTO `+s.tasks()+` ("`+strings.Join(columns, `", "`)+`")
+		VALUES `+strings.Join(rows, ", ")+`
+		ON CONFLICT ("name", "dedup_key") DO NOTHING
+		RETURNING "id"`, sqliteValues(args)...)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	inserted := map[string]bool{}
+	for result.Next() {
+		var id string
+		err = result.Scan(&id)
+		if err != nil {
+			return err
+		}
+		inserted[id] = true
+	}
+	if err = result.Err(); err != nil {
+		return err
+	}
+	if len(inserted) == len(tasks) {
+		return nil
+	}
+
+	// The tasks which were not inserted are duplicates
+	var keys []interface{}
+	for _, task := range tasks {
+		if !inserted[task.ID] {
+			keys = append(keys, task.DedupKey.String)
+		}
+	}
+	var existing m.TaskSlice
+	err = queries.Raw(`SELECT * FROM `+s.tasks()+` WHERE "dedup_key" IN (`+placeholders(len(keys))+`)`, keys...).
+		Bind(ctx, tx, &existing)
+	if err != nil {
+		return err
+	}
+	return replaceDuplicates(tasks, inserted, existing)
+}
+
+// Claim implements Store. SQLite has a single writer so the update is atomic
+// without locking the rows, the claimed ids are returned by the update itself.
+func (s *SQLiteStore) Claim(ctx context.Context, names, queues []string, lease time.Time, limit int) (m.TaskSlice, error) {
+	if len(names) == 0 || len(queues) == 0 {
+		return nil, nil
+	}
+	err := s.requeueExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{lease.UTC(), time.Now().UTC()}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	for _, queue := range queues {
+		args = append(args, queue)
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, `UPDATE `+s.tasks()+` SET "status"='doing', "lease_until"=? WHERE "id" IN (
+		SELECT "id" FROM `+s.tasks()+`
+		WHERE "status"='todo' AND "todo_date"<? AND "name" IN (`+placeholders(len(names))+`)
+			AND "queue" IN (`+placeholders(len(queues))+`)
+		ORDER BY "todo_date" ASC LIMIT ?
+	) RETURNING "id"`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.claimed(ctx, rows)
+}
+
+// ClaimByKey implements Store, SQLite runs a single writer at once so the
+// tasks doing are up to date. The parameters are numbered with ?NNN, SQLite
+// numbers $NNN parameters in the order they appear.
+func (s *SQLiteStore) ClaimByKey(ctx context.Context, name string, queues []string, key string, max int, lease time.Time, limit int) (m.TaskSlice, error) {
+	if len(queues) == 0 {
+		return nil, nil
+	}
+	// The expired tasks don't hold their key anymore
+	err := s.requeueExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := "$." + strconv.Quote(key)
+	args := []interface{}{time.Now().UTC(), name, path, max, limit, lease.UTC()}
+	inQueue := make([]string, len(queues))
+	for i, queue := range queues {
+		args = append(args, queue)
+		inQueue[i] = "?" + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, `UPDATE `+s.tasks()+` SET "status"='doing', "lease_until"=?6 WHERE "id" IN (
+		SELECT "id" FROM (
+			SELECT "id", "todo_date", json_extract("user_args", ?3) AS "key",
+				row_number() OVER (PARTITION BY json_extract("user_args", ?3) ORDER BY "todo_date") AS "rank"
+			FROM `+s.tasks()+` WHERE "status"='todo' AND "todo_date"<?1 AND "name"=?2
+				AND "queue" IN (`+strings.Join(inQueue, ",")+`)
+		) AS c WHERE c."key" IS NULL OR c."rank" <= ?4 - (
+			SELECT count(*) FROM `+s.tasks()+` AS d
+			WHERE d."status"='doing' AND d."name"=?2 AND json_extract(d."user_args", ?3)=c."key"
+		)
+		ORDER BY c."todo_date" ASC LIMIT ?5
+	) RETURNING "id"`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.claimed(ctx, rows)
+}
+
+// requeueExpired puts back to todo the doing tasks whose lease expired and
+// records the lost execution as a failed attempt
+func (s *SQLiteStore) requeueExpired(ctx context.Context) error {
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `UPDATE `+s.tasks()+` SET "status"='todo', "retry"="retry"+1
+		WHERE "status"='doing' AND "lease_until"<? RETURNING "id", "actual_step", "retry"`, now)
+	if err != nil {
+		return err
+	}
+	var attempts []*Attempt
+	for rows.Next() {
+		a := &Attempt{StartedAt: now, FinishedAt: now, Error: null.StringFrom(ErrLeaseExpired.Error())}
+		err := rows.Scan(&a.TaskID, &a.Step, &a.Retry)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		a.Retry--
+		attempts = append(attempts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	for _, a := range attempts {
+		err = s.addAttempt(ctx, tx, a)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ExtendLease implements Store
+func (s *SQLiteStore) ExtendLease(ctx context.Context, lease time.Time, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{lease.UTC()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE `+s.tasks()+` SET "lease_until"=?
+		WHERE "status"='doing' AND "id" IN (`+placeholders(len(ids))+`)`, args...)
+	return err
+}
+
+// claimed returns the tasks whose ids are returned by a claim
+func (s *SQLiteStore) claimed(ctx context.Context, rows *sql.Rows) (m.TaskSlice, error) {
+	defer rows.Close()
+
+	var ids []interface{}
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var tasks m.TaskSlice
+	err := queries.Raw(`SELECT * FROM `+s.tasks()+` WHERE "id" IN (`+placeholders(len(ids))+`) ORDER BY "todo_date" ASC`, ids...).
+		Bind(ctx, s.db, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Update implements Store, the tasks are updated in a single transaction
+func (s *SQLiteStore) Update(ctx context.Context, columns []string, tasks ...*m.Task) error {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+	err := checkColumns(columns)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sets := `"` + strings.Join(columns, `"=?, "`) + `"=?`
+	stmt, err := tx.PrepareContext(ctx, `UPDATE `+s.tasks()+` SET `+sets+` WHERE "id"=?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, task := range tasks {
+		values, err := taskColumnValues(task, columns)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, sqliteValues(append(values, task.ID))...)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateClaimed implements Store, the tasks are updated in a single
+// transaction
+func (s *SQLiteStore) UpdateClaimed(ctx context.Context, columns []string, tasks ...ClaimedTask) ([]string, error) {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+	err := checkColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sets := `"` + strings.Join(columns, `"=?, "`) + `"=?`
+	stmt, err := tx.PrepareContext(ctx, `UPDATE `+s.tasks()+` SET `+sets+`
+		WHERE "id"=? AND "status"='doing' AND "retry"=?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	updated := map[string]bool{}
+	for _, c := range tasks {
+		values, err := taskColumnValues(c.Task, columns)
+		if err != nil {
+			return nil, err
+		}
+		res, err := stmt.ExecContext(ctx, sqliteValues(append(values, c.Task.ID, c.Retry))...)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		updated[c.Task.ID] = n > 0
+	}
+	return lostClaims(tasks, updated), tx.Commit()
+}
+
+// UpdateIf implements Store
+func (s *SQLiteStore) UpdateIf(ctx context.Context, columns []string, task *m.Task, statuses ...string) error {
+	if len(columns) == 0 {
+		columns = taskColumns
+	}
+	err := checkColumns(columns)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		return ErrInvalidStatus
+	}
+
+	values, err := taskColumnValues(task, columns)
+	if err != nil {
+		return err
+	}
+	args := sqliteValues(append(values, task.ID))
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+
+	sets := `"` + strings.Join(columns, `"=?, "`) + `"=?`
+	res, err := s.db.ExecContext(ctx, `UPDATE `+s.tasks()+` SET `+sets+`
+		WHERE "id"=? AND "status" IN (`+placeholders(len(statuses))+`)`, args...)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrInvalidStatus
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *SQLiteStore) Get(ctx context.Context, id string) (*m.Task, error) {
+	task := &m.Task{}
+	err := queries.Raw(`SELECT * FROM `+s.tasks()+` WHERE "id"=?`, id).Bind(ctx, s.db, task)
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// List implements Store
+func (s *SQLiteStore) List(ctx context.Context, f TaskFilter) (m.TaskSlice, error) {
+	var where []string
+	var args []interface{}
+	if f.Name != "" {
+		where = append(where, `"name"=?`)
+		args = append(args, f.Name)
+	}
+	if f.Status != "" {
+		where = append(where, `"status"=?`)
+		args = append(args, f.Status)
+	}
+	if !f.After.IsZero() {
+		where = append(where, `"todo_date">=?`)
+		args = append(args, f.After.UTC())
+	}
+	if !f.Before.IsZero() {
+		where = append(where, `"todo_date"<?`)
+		args = append(args, f.Before.UTC())
+	}
+
+	query := `SELECT * FROM ` + s.tasks()
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+
+	limit := -1
+	if f.Limit > 0 {
+		limit = f.Limit
+	}
+	query += ` ORDER BY "created_at" DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, f.Offset)
+
+	var tasks m.TaskSlice
+	err := queries.Raw(query, args...).Bind(ctx, s.db, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Counts implements Store
+func (s *SQLiteStore) Counts(ctx context.Context) ([]NameStatusCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT "name", "status", count(*), min("todo_date") FROM `+s.tasks()+`
+		GROUP BY "name", "status" ORDER BY "name", "status"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []NameStatusCount
+	for rows.Next() {
+		var c NameStatusCount
+		var oldestDue string
+		err = rows.Scan(&c.Name, &c.Status, &c.Count, &oldestDue)
+		if err != nil {
+			return nil, err
+		}
+		// The type of aggregated timestamps is lost
+		c.OldestDue, err = time.Parse(sqliteTimeFormat, oldestDue)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// PendingSteps implements Store
+func (s *SQLiteStore) PendingSteps(ctx context.Context) ([]StepCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT "name", "version", "actual_step", count(*) FROM `+s.tasks()+`
+		WHERE "status" IN ('todo', 'doing')
+		GROUP BY "name", "version", "actual_step" ORDER BY "name", "version", "actual_step"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []StepCount
+	for rows.Next() {
+		var c StepCount
+		err = rows.Scan(&c.Name, &c.Version, &c.Step, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// AddAttempt implements Store
+func (s *SQLiteStore) AddAttempt(ctx context.Context, a *Attempt) error {
+	return s.addAttempt(ctx, s.db, a)
+}
+
+func (s *SQLiteStore) addAttempt(ctx context.Context, exec boil.ContextExecutor, a *Attempt) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	_, err = exec.ExecContext(ctx,
+		`INSERT INTO `+s.attempts()+` ("id", "task_id", "step", "retry", "started_at", "finished_at", "error") VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id.String(), a.TaskID, a.Step, a.Retry, a.StartedAt.UTC(), a.FinishedAt.UTC(), a.Error)
+	if err != nil {
+		return err
+	}
+	a.ID = id.String()
+	return nil
+}
+
+// Attempts implements Store
+func (s *SQLiteStore) Attempts(ctx context.Context, taskID string) ([]*Attempt, error) {
+	var attempts []*Attempt
+	err := queries.Raw(`SELECT * FROM `+s.attempts()+` WHERE "task_id"=? ORDER BY "started_at" ASC`, taskID).
+		Bind(ctx, s.db, &attempts)
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Throughput implements Store
+func (s *SQLiteStore) Throughput(ctx context.Context, since time.Time) ([]*ThroughputBucket, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT strftime('%Y-%m-%d %H:00:00', "finished_at") AS "hour",
+		sum("error" IS NULL), sum("error" IS NOT NULL)
+		FROM `+s.attempts()+` WHERE "finished_at" >= ?
+		GROUP BY 1 ORDER BY 1`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []*ThroughputBucket
+	for rows.Next() {
+		b := &ThroughputBucket{}
+		var hour string
+		err = rows.Scan(&hour, &b.Succeeded, &b.Failed)
+		if err != nil {
+			return nil, err
+		}
+		b.Hour, err = time.Parse("2006-01-02 15:04:05", hour)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// UseTokens implements Store, the transaction holds the database write lock
+// until the tokens are taken
+func (s *SQLiteStore) UseTokens(ctx context.Context, name string, window time.Time, max, n int) (int, error) {
+	return useTokens(ctx, s.db, s.rateLimits(), "", name, window, max, n)
+}
+
+// Purge implements Store, the tasks are copied and deleted in a single
+// transaction
+func (s *SQLiteStore) Purge(ctx context.Context, status string, before time.Time, archive bool, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Every statement selects the same tasks, the first one takes the write
+	// lock until the end of the transaction
+	purged := `(SELECT "id" FROM ` + s.tasks() + `
+		WHERE "status"=?1 AND "finished_at"<?2 ORDER BY "finished_at" ASC LIMIT ?3)`
+	args := []interface{}{status, before.UTC(), limit}
+	if archive {
+		columns := archiveColumns()
+		_, err = tx.ExecContext(ctx, `INSERT INTO `+s.archive()+` (`+columns+`)
+			SELECT `+columns+` FROM `+s.tasks()+` WHERE "id" IN `+purged, args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// The foreign keys are only enforced when enabled on the connection
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+s.attempts()+` WHERE "task_id" IN `+purged, args...)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM `+s.tasks()+` WHERE "id" IN `+purged, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
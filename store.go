@@ -0,0 +1,353 @@
+package tasker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// Store persists the tasks and their attempts
+type Store interface {
+	// Enqueue inserts new tasks at once and fills their generated columns,
+	// an ID is generated for the tasks without one. A task with the name and
+	// dedup key of an existing task is not inserted, it is overwritten by the
+	// existing task instead.
+	Enqueue(ctx context.Context, tasks ...*m.Task) error
+	// Claim marks up to limit due todo tasks named after one of names and in
+	// one of queues as doing until lease and returns them, oldest todo date
+	// first. A task is never returned by two concurrent claims. The doing
+	// tasks whose lease expired, like the ones of a crashed scheduler, are
+	// put back to todo first with one more retry.
+	Claim(ctx context.Context, names, queues []string, lease time.Time, limit int) (m.TaskSlice, error)
+	// ClaimByKey is Claim for the tasks of a single name, it leaves at most
+	// max tasks doing at once with the same value of the key field of their
+	// user args, counting the ones already doing. The tasks without the field
+	// are not limited. A task is never returned by two concurrent claims and
+	// concurrent claims respect max.
+	ClaimByKey(ctx context.Context, name string, queues []string, key string, max int, lease time.Time, limit int) (m.TaskSlice, error)
+	// ExtendLease sets the lease of the doing tasks with the given ids
+	ExtendLease(ctx context.Context, lease time.Time, ids ...string) error
+	// Update saves the given columns of the tasks at once, all the columns
+	// written by Enqueue when columns is empty
+	Update(ctx context.Context, columns []string, tasks ...*m.Task) error
+	// UpdateClaimed saves the given columns of tasks at once like Update,
+	// only while they are doing with the retry count they were claimed with.
+	// It returns the ids of the tasks left untouched, their lease expired
+	// and they were claimed again.
+	UpdateClaimed(ctx context.Context, columns []string, tasks ...ClaimedTask) ([]string, error)
+	// UpdateIf saves the given columns of a task only while its status is
+	// one of statuses, it returns ErrInvalidStatus otherwise
+	UpdateIf(ctx context.Context, columns []string, task *m.Task, statuses ...string) error
+	// Get returns the task with the given id or ErrTaskNotFound
+	Get(ctx context.Context, id string) (*m.Task, error)
+	// List returns the tasks matching the filter, most recent first
+	List(ctx context.Context, f TaskFilter) (m.TaskSlice, error)
+	// Counts returns the number of tasks per name and status
+	Counts(ctx context.Context) ([]NameStatusCount, error)
+	// PendingSteps returns the number of todo and doing tasks per name,
+	// version and actual step
+	PendingSteps(ctx context.Context) ([]StepCount, error)
+
+	// AddAttempt records the execution of a step and sets the attempt ID
+	AddAttempt(ctx context.Context, a *Attempt) error
+	// Attempts returns the attempt history of a task, oldest first
+	Attempts(ctx context.Context, taskID string) ([]*Attempt, error)
+	// Throughput returns the number of step executions per hour since the
+	// given date, hours without any execution are omitted
+	Throughput(ctx context.Context, since time.Time) ([]*ThroughputBucket, error)
+
+	// UseTokens takes up to n of the max tokens of name in the rate limit
+	// window starting at window and returns the number taken, a negative n
+	// gives tokens back. The tokens used are reset when a new window starts.
+	UseTokens(ctx context.Context, name string, window time.Time, max, n int) (int, error)
+
+	// Purge deletes up to limit tasks with the status which finished before
+	// the given date, oldest first, and returns their number. The tasks are
+	// copied to the archive table first when archive is set.
+	Purge(ctx context.Context, status string, before time.Time, archive bool, limit int) (int, error)
+}
+
+var store Store
+
+// InitStore sets the store used by default, Init uses a PostgresStore
+func InitStore(s Store) {
+	store = s
+	if ctx == nil {
+		ctx = context.Background()
+	}
+}
+
+// defaultStore returns the store set by Init or InitStore
+func defaultStore() Store {
+	if store == nil {
+		panic("tasker: Init or InitStore must be called first")
+	}
+	return store
+}
+
+func (s *Scheduler) store() Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return defaultStore()
+}
+
+// ClaimedTask is a task written by the scheduler executing it, Retry is the
+// retry count it was claimed with. The retry count of a task is incremented
+// when its lease expires, the task then belongs to the next scheduler
+// claiming it.
+type ClaimedTask struct {
+	Task  *m.Task
+	Retry int
+}
+
+// lostClaims returns the ids of the tasks which were not updated
+func lostClaims(tasks []ClaimedTask, updated map[string]bool) []string {
+	var lost []string
+	for _, c := range tasks {
+		if !updated[c.Task.ID] {
+			lost = append(lost, c.Task.ID)
+		}
+	}
+	return lost
+}
+
+// context is the context of the scheduler executing the task, the one set
+// by Init or InitStore otherwise
+func (u *UserTask) context() context.Context {
+	if u.ctx != nil {
+		return u.ctx
+	}
+	if ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+func (u *UserTask) store() Store {
+	if u.taskStore != nil {
+		return u.taskStore
+	}
+	return defaultStore()
+}
+
+// Tables names the tables of a tasker instance, instances with different
+// tables can share a database
+type Tables struct {
+	// Schema is the Postgres schema of the tables, the search path is used
+	// when empty. It is ignored by SQLite.
+	Schema string
+	// Tasks is the tasks table, "tasks" by default
+	Tasks string
+	// Attempts is the attempt history table, "task_attempts" by default or
+	// Tasks followed by "_attempts" when Tasks is set
+	Attempts string
+	// Migrations records the applied migrations, "tasker_schema_migrations"
+	// by default or Tasks followed by "_schema_migrations" when Tasks is set
+	Migrations string
+	// RateLimits holds the tokens of the distributed rate limits,
+	// "task_rate_limits" by default or Tasks followed by "_rate_limits" when
+	// Tasks is set
+	RateLimits string
+	// Archive receives the tasks archived by the retention policies,
+	// "tasks_archive" by default or Tasks followed by "_archive" when Tasks is
+	// set
+	Archive string
+}
+
+// withDefaults fills the empty table names
+func (t Tables) withDefaults() Tables {
+	if t.Tasks == "" {
+		t.Tasks = "tasks"
+		if t.Attempts == "" {
+			t.Attempts = "task_attempts"
+		}
+		if t.Migrations == "" {
+			t.Migrations = "tasker_schema_migrations"
+		}
+		if t.RateLimits == "" {
+			t.RateLimits = "task_rate_limits"
+		}
+	}
+	if t.Attempts == "" {
+		t.Attempts = t.Tasks + "_attempts"
+	}
+	if t.Migrations == "" {
+		t.Migrations = t.Tasks + "_schema_migrations"
+	}
+	if t.RateLimits == "" {
+		t.RateLimits = t.Tasks + "_rate_limits"
+	}
+	if t.Archive == "" {
+		t.Archive = t.Tasks + "_archive"
+	}
+	return t
+}
+
+// finishedChannel is the Postgres channel notified with the id of the tasks
+// reaching a final status
+func (t Tables) finishedChannel() string {
+	if t.Schema == "" {
+		return t.Tasks + "_finished"
+	}
+	return t.Schema + "." + t.Tasks + "_finished"
+}
+
+// qualify returns the quoted name of an object of the schema
+func (t Tables) qualify(name string) string {
+	if t.Schema == "" {
+		return quoteIdent(name)
+	}
+	return quoteIdent(t.Schema) + "." + quoteIdent(name)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// taskColumns are the columns written by the stores on insert and update, in
+// the order of taskValues
+var taskColumns = []string{
+	m.TaskColumns.TodoDate, m.TaskColumns.Name, m.TaskColumns.ActualStep, m.TaskColumns.Status,
+	m.TaskColumns.Retry, m.TaskColumns.UserBuffer, m.TaskColumns.UserArgs, m.TaskColumns.TraceContext,
+	m.TaskColumns.DedupKey, m.TaskColumns.Result, m.TaskColumns.Progress, m.TaskColumns.Queue,
+	m.TaskColumns.Version, m.TaskColumns.LeaseUntil, m.TaskColumns.FinishedAt,
+}
+
+func taskValues(task *m.Task) []interface{} {
+	return []interface{}{
+		task.TodoDate, task.Name, task.ActualStep, task.Status,
+		task.Retry, task.UserBuffer, task.UserArgs, task.TraceContext,
+		task.DedupKey, task.Result, task.Progress, task.Queue, task.Version, task.LeaseUntil, task.FinishedAt,
+	}
+}
+
+// taskColumnValues returns the values of the given columns of the task, all
+// of them must be in taskColumns
+func taskColumnValues(task *m.Task, columns []string) ([]interface{}, error) {
+	err := checkColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	all := taskValues(task)
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		values[i] = all[columnIndex(c)]
+	}
+	return values, nil
+}
+
+// checkColumns returns an error if a column is not in taskColumns, the
+// column names are written in the queries
+func checkColumns(columns []string) error {
+	for _, c := range columns {
+		if columnIndex(c) < 0 {
+			return fmt.Errorf("tasker: column %s cannot be updated", c)
+		}
+	}
+	return nil
+}
+
+func columnIndex(column string) int {
+	for i, c := range taskColumns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// changedColumns returns the columns of taskColumns whose value differs
+// between the saved version of a task and the current one
+func changedColumns(saved, task *m.Task) []string {
+	before, after := taskValues(saved), taskValues(task)
+	var columns []string
+	for i, c := range taskColumns {
+		if !sameValue(before[i], after[i]) {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+func sameValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Equal(b.(time.Time))
+	case null.JSON:
+		b := b.(null.JSON)
+		return a.Valid == b.Valid && bytes.Equal(a.JSON, b.JSON)
+	default:
+		return a == b
+	}
+}
+
+// copyTask returns a copy of the task which doesn't share its JSON columns
+func copyTask(task *m.Task) *m.Task {
+	c := *task
+	c.UserBuffer.JSON = append([]byte(nil), task.UserBuffer.JSON...)
+	c.UserArgs.JSON = append([]byte(nil), task.UserArgs.JSON...)
+	c.TraceContext.JSON = append([]byte(nil), task.TraceContext.JSON...)
+	c.Result.JSON = append([]byte(nil), task.Result.JSON...)
+	c.Progress.JSON = append([]byte(nil), task.Progress.JSON...)
+	return &c
+}
+
+// setEnqueueDefaults sets the same defaults as the tasks table
+func setEnqueueDefaults(task *m.Task) error {
+	if task.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		task.ID = id.String()
+	}
+
+	now := time.Now()
+	task.CreatedAt = now
+	if task.TodoDate.IsZero() {
+		task.TodoDate = now
+	}
+	if task.Status == "" {
+		task.Status = m.TaskStatusTodo
+	}
+	return nil
+}
+
+// dedupKey identifies the tasks which must not be enqueued twice, it is empty
+// for the tasks without dedup key
+func dedupKey(task *m.Task) string {
+	if !task.DedupKey.Valid {
+		return ""
+	}
+	return task.Name + "\x00" + task.DedupKey.String
+}
+
+// replaceDuplicates overwrites the tasks which were not inserted by the
+// existing task with the same dedup key
+func replaceDuplicates(tasks []*m.Task, inserted map[string]bool, existing m.TaskSlice) error {
+	byKey := map[string]*m.Task{}
+	for _, task := range existing {
+		byKey[dedupKey(task)] = task
+	}
+
+	for _, task := range tasks {
+		if inserted[task.ID] {
+			continue
+		}
+		dup, ok := byKey[dedupKey(task)]
+		if !ok || dedupKey(task) == "" {
+			return fmt.Errorf("tasker: task %s was not inserted", task.ID)
+		}
+		*task = *dup
+	}
+	return nil
+}
@@ -0,0 +1,899 @@
+package tasker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/volatiletech/null"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+// useMemoryStore replaces the default store by an empty MemoryStore for the
+// duration of the test
+func useMemoryStore(t *testing.T) *MemoryStore {
+	s := NewMemoryStore()
+	previous := store
+	InitStore(s)
+	t.Cleanup(func() { store = previous })
+	return s
+}
+
+// defaultQueues are the queues claimed by a scheduler serving no other queue
+var defaultQueues = []string{""}
+
+// testStore is the behavior expected from every Store, s must be empty
+func testStore(t *testing.T, s Store) {
+	now := time.Now()
+	lease := now.Add(time.Hour)
+	enqueue := func(name string, todoDate time.Time) *m.Task {
+		task := &m.Task{Name: name, Status: m.TaskStatusTodo, TodoDate: todoDate}
+		err := s.Enqueue(ctx, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.ID == "" {
+			t.Fatal("Enqueue should set the task ID")
+		}
+		return task
+	}
+
+	late := enqueue("test", now.Add(-2*time.Hour))
+	early := enqueue("test", now.Add(-time.Hour))
+	enqueue("test", now.Add(time.Hour))
+	other := enqueue("other", now.Add(-time.Hour))
+
+	claimed, err := s.Claim(ctx, []string{"test"}, defaultQueues, lease, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != late.ID || claimed[0].Status != m.TaskStatusDoing {
+		t.Fatalf("Expected to claim the oldest due task, got %+v", claimed)
+	}
+
+	claimed, err = s.Claim(ctx, []string{"test"}, defaultQueues, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != early.ID {
+		t.Fatalf("Expected to claim only the remaining due task, got %+v", claimed)
+	}
+
+	task := claimed[0]
+	task.Status = m.TaskStatusDone
+	task.ActualStep = "step2"
+	err = task.UserBuffer.Marshal(map[string]int{"counter": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = task.Result.Marshal("report-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Update(ctx, nil, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != m.TaskStatusDone || got.ActualStep != "step2" || !got.UserBuffer.Valid || !got.Result.Valid {
+		t.Errorf("Update was not saved, got %+v", got)
+	}
+
+	// Only the given columns are written
+	task.Status = m.TaskStatusError
+	task.Retry = 3
+	err = s.Update(ctx, []string{m.TaskColumns.Retry}, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != m.TaskStatusDone || got.Retry != 3 {
+		t.Errorf("Expected only the retry to be updated, got %+v", got)
+	}
+	task.Status = m.TaskStatusDone
+	err = s.Update(ctx, []string{"created_at"}, task)
+	if err == nil {
+		t.Errorf("Expected an error when updating an unknown column")
+	}
+
+	_, err = s.Get(ctx, "c9f51923-293a-4e3b-a49f-cccd71db4679")
+	if err != ErrTaskNotFound {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+	_, err = s.Get(ctx, "not an id")
+	if err != ErrTaskNotFound {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+
+	tasks, err := s.List(ctx, TaskFilter{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 3 {
+		t.Errorf("Expected 3 tasks, got %d", len(tasks))
+	}
+	tasks, err = s.List(ctx, TaskFilter{Status: m.TaskStatusTodo, Before: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != other.ID {
+		t.Errorf("Expected the other task, got %+v", tasks)
+	}
+	tasks, err = s.List(ctx, TaskFilter{Limit: 2, Offset: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("Expected 1 task, got %d", len(tasks))
+	}
+
+	counts, err := s.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NameStatusCount{
+		{Name: "other", Status: m.TaskStatusTodo, Count: 1},
+		{Name: "test", Status: m.TaskStatusTodo, Count: 1},
+		{Name: "test", Status: m.TaskStatusDoing, Count: 1},
+		{Name: "test", Status: m.TaskStatusDone, Count: 1},
+	}
+	if len(counts) != len(expected) {
+		t.Fatalf("Expected %d counts, got %+v", len(expected), counts)
+	}
+	total := map[string]int64{}
+	for _, c := range counts {
+		total[c.Name+":"+c.Status] += c.Count
+	}
+	for _, c := range expected {
+		if total[c.Name+":"+c.Status] != c.Count {
+			t.Errorf("Expected %d %s tasks in %s, got %+v", c.Count, c.Name, c.Status, counts)
+		}
+	}
+
+	for i, stepErr := range []null.String{null.String{}, null.StringFrom("failed")} {
+		a := &Attempt{
+			TaskID:     task.ID,
+			Step:       "step1",
+			Retry:      i,
+			StartedAt:  now.Add(time.Duration(i) * time.Second),
+			FinishedAt: now.Add(time.Duration(i+1) * time.Second),
+			Error:      stepErr,
+		}
+		err = s.AddAttempt(ctx, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.ID == "" {
+			t.Fatal("AddAttempt should set the attempt ID")
+		}
+	}
+
+	attempts, err := s.Attempts(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[0].Retry != 0 || !attempts[1].Error.Valid {
+		t.Errorf("Unexpected attempts %+v", attempts)
+	}
+
+	buckets, err := s.Throughput(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var succeeded, failed int64
+	for _, b := range buckets {
+		succeeded += b.Succeeded
+		failed += b.Failed
+	}
+	if succeeded != 1 || failed != 1 {
+		t.Errorf("Unexpected throughput %+v", buckets)
+	}
+
+	batch := []*m.Task{
+		{Name: "dedup", DedupKey: null.StringFrom("a")},
+		{Name: "dedup", DedupKey: null.StringFrom("a")},
+		{Name: "dedup"},
+		{Name: "other", DedupKey: null.StringFrom("a")},
+	}
+	err = s.Enqueue(ctx, batch...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].ID == "" || batch[1].ID != batch[0].ID {
+		t.Errorf("Tasks with the same dedup key should be the same task")
+	}
+	if batch[2].ID == "" || batch[3].ID == "" || batch[3].ID == batch[0].ID {
+		t.Errorf("Dedup keys should be scoped by task name")
+	}
+
+	again := &m.Task{Name: "dedup", DedupKey: null.StringFrom("a"), ActualStep: "step2"}
+	err = s.Enqueue(ctx, again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != batch[0].ID || again.ActualStep != "" {
+		t.Errorf("Expected the existing task, got %+v", again)
+	}
+
+	// A window unique to the run, the Postgres rows survive the tests
+	window := time.Now()
+	for _, c := range []struct{ n, taken int }{{2, 2}, {2, 1}, {1, 0}, {-2, -2}, {5, 2}} {
+		taken, err := s.UseTokens(ctx, "limited", window, 3, c.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken != c.taken {
+			t.Errorf("Expected to take %d tokens out of %d, took %d", c.taken, c.n, taken)
+		}
+	}
+	taken, err := s.UseTokens(ctx, "limited", window.Add(time.Second), 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taken != 3 {
+		t.Errorf("Expected the tokens to be reset by a new window, took %d", taken)
+	}
+
+	var keyed []*m.Task
+	for i, args := range []string{`{"user": "a"}`, `{"user": "a"}`, `{"user": "b"}`, `{}`} {
+		task := &m.Task{Name: "keyed", TodoDate: now.Add(time.Duration(i-10) * time.Second), UserArgs: null.JSONFrom([]byte(args))}
+		keyed = append(keyed, task)
+	}
+	err = s.Enqueue(ctx, keyed...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.ClaimByKey(ctx, "keyed", defaultQueues, "user", 1, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 3 || claimed[0].ID != keyed[0].ID {
+		t.Fatalf("Expected to claim one task per user and the task without user, got %+v", claimed)
+	}
+	claimed, err = s.ClaimByKey(ctx, "keyed", defaultQueues, "user", 1, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("Expected the second task of the user to wait, got %+v", claimed)
+	}
+	keyed[0].Status = m.TaskStatusDone
+	err = s.Update(ctx, []string{m.TaskColumns.Status}, keyed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.ClaimByKey(ctx, "keyed", defaultQueues, "user", 1, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != keyed[1].ID {
+		t.Fatalf("Expected the second task of the user, got %+v", claimed)
+	}
+
+	var finished []*m.Task
+	for i, status := range []string{m.TaskStatusDone, m.TaskStatusDone, m.TaskStatusDone, m.TaskStatusError} {
+		finished = append(finished, &m.Task{Name: "finished", Status: status, TodoDate: now,
+			FinishedAt: null.TimeFrom(now.Add(-48*time.Hour - time.Duration(i)*time.Minute))})
+	}
+	err = s.Enqueue(ctx, finished...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddAttempt(ctx, &Attempt{TaskID: finished[0].ID, Step: "step1", StartedAt: now, FinishedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []int{2, 1, 0} {
+		n, err := s.Purge(ctx, m.TaskStatusDone, now.Add(-24*time.Hour), false, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Errorf("Expected to purge %d tasks, purged %d", expected, n)
+		}
+	}
+	n, err := s.Purge(ctx, m.TaskStatusError, now.Add(-24*time.Hour), true, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected to archive 1 task, archived %d", n)
+	}
+	for _, task := range finished {
+		_, err = s.Get(ctx, task.ID)
+		if err != ErrTaskNotFound {
+			t.Errorf("Expected the task to be purged, got %v", err)
+		}
+	}
+	attempts, err = s.Attempts(ctx, finished[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 0 {
+		t.Errorf("Expected the attempts of the task to be purged, got %d", len(attempts))
+	}
+	_, err = s.Get(ctx, task.ID)
+	if err != nil {
+		t.Errorf("Recent done tasks must be kept, got %v", err)
+	}
+
+	gpu := &m.Task{Name: "queued", TodoDate: now.Add(-time.Hour), Queue: "gpu"}
+	err = s.Enqueue(ctx, gpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.Claim(ctx, []string{"queued"}, defaultQueues, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("Expected the task of the gpu queue to be left, got %+v", claimed)
+	}
+	claimed, err = s.Claim(ctx, []string{"queued"}, []string{"", "gpu"}, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != gpu.ID || claimed[0].Queue != "gpu" {
+		t.Fatalf("Expected to claim the task of the gpu queue, got %+v", claimed)
+	}
+
+	cancelled := copyTask(claimed[0])
+	cancelled.Status = m.TaskStatusCancelled
+	err = s.UpdateIf(ctx, []string{m.TaskColumns.Status}, cancelled, m.TaskStatusTodo, m.TaskStatusError)
+	if err != ErrInvalidStatus {
+		t.Errorf("Expected a claimed task not to be cancelled, got %v", err)
+	}
+	err = s.UpdateIf(ctx, []string{m.TaskColumns.Status}, cancelled, m.TaskStatusDoing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := s.Get(ctx, cancelled.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != m.TaskStatusCancelled {
+		t.Errorf("Expected the task to be cancelled, got %s", saved.Status)
+	}
+	cancelled.Status = m.TaskStatusDoing
+	err = s.Update(ctx, []string{m.TaskColumns.Status}, cancelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crashed := &m.Task{Name: "leased", TodoDate: now.Add(-time.Hour)}
+	err = s.Enqueue(ctx, crashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.Claim(ctx, []string{"leased"}, defaultQueues, now.Add(-time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || !claimed[0].LeaseUntil.Valid {
+		t.Fatalf("Expected to claim the task with a lease, got %+v", claimed)
+	}
+	err = s.ExtendLease(ctx, lease, crashed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.Claim(ctx, []string{"leased"}, defaultQueues, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("Expected a renewed lease to keep the task, got %+v", claimed)
+	}
+	err = s.ExtendLease(ctx, now.Add(-time.Second), crashed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.Claim(ctx, []string{"leased"}, defaultQueues, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != crashed.ID || claimed[0].Retry != 1 {
+		t.Fatalf("Expected the expired task to be claimed again with a retry, got %+v", claimed)
+	}
+	attempts, err = s.Attempts(ctx, crashed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 1 || attempts[0].Retry != 0 || attempts[0].Error.String != ErrLeaseExpired.Error() {
+		t.Fatalf("Expected an attempt for the expired lease, got %+v", attempts)
+	}
+	stale := *crashed
+	stale.Status = m.TaskStatusDone
+	lost, err := s.UpdateClaimed(ctx, []string{m.TaskColumns.Status}, ClaimedTask{Task: &stale, Retry: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 1 || lost[0] != crashed.ID {
+		t.Fatalf("Expected the stale worker not to save the task, got %v", lost)
+	}
+	lost, err = s.UpdateClaimed(ctx, []string{m.TaskColumns.Status}, ClaimedTask{Task: &stale, Retry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 0 {
+		t.Fatalf("Expected the worker holding the lease to save the task, got %v", lost)
+	}
+
+	keyedCrash := &m.Task{Name: "keyed", TodoDate: now.Add(-time.Second), UserArgs: null.JSONFrom([]byte(`{"user": "c"}`))}
+	err = s.Enqueue(ctx, keyedCrash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = s.ClaimByKey(ctx, "keyed", defaultQueues, "user", 1, now.Add(-time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != keyedCrash.ID {
+		t.Fatalf("Expected to claim the task of the user, got %+v", claimed)
+	}
+	claimed, err = s.ClaimByKey(ctx, "keyed", defaultQueues, "user", 1, lease, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != keyedCrash.ID {
+		t.Fatalf("Expected the expired task not to hold its key, got %+v", claimed)
+	}
+
+	steps, err := s.PendingSteps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range steps {
+		if c.Name == "finished" {
+			t.Errorf("Expected only the pending tasks, got %+v", c)
+		}
+		found = found || c == StepCount{Name: "queued", Version: 0, Step: "", Count: 1}
+	}
+	if !found {
+		t.Errorf("Expected the claimed task to be pending, got %+v", steps)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestPostgresStore(t *testing.T) {
+	err := cleanDB("tasks")
+	if err != nil {
+		t.Fatal("Cannot clean db:" + err.Error())
+	}
+
+	testStore(t, NewPostgresStore(dbh))
+}
+
+func TestSchedulerExecOnce(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:     "test",
+			MaxRetry: 1,
+			Steps:    []Step{{Name: "step1", Exec: testStep}, {Name: "step2", Exec: testStep2}},
+		}},
+	}
+
+	task, err := s.Enqueue(ctx, EnqueueParams{Name: "test", TodoDate: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 executed task, got %d", n)
+	}
+
+	task, err = GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != m.TaskStatusDone || task.ActualStep != "step2" {
+		t.Errorf("Task should be done, got %+v", task)
+	}
+
+	attempts, err := GetAttempts(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(attempts))
+	}
+
+	n, err = s.ExecOnce()
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing to execute, got %d %v", n, err)
+	}
+}
+
+func TestSchedulerWorkers(t *testing.T) {
+	useMemoryStore(t)
+
+	s := &Scheduler{
+		Tasks: []Task{{
+			Name:     "test",
+			MaxRetry: 1,
+			Steps:    []Step{{Name: "step1", Exec: testStep}, {Name: "step2", Exec: testStep2}},
+		}},
+		ClaimBatchSize: 5,
+		Workers:        3,
+	}
+
+	params := make([]EnqueueParams, 7)
+	for i := range params {
+		params[i] = EnqueueParams{Name: "test", TodoDate: time.Now().Add(-time.Second)}
+	}
+	ids, err := s.EnqueueMany(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{5, 2, 0} {
+		n, err := s.ExecOnce()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Fatalf("Expected %d executed tasks, got %d", expected, n)
+		}
+	}
+
+	for _, id := range ids {
+		task, err := GetTask(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Status != m.TaskStatusDone || task.ActualStep != "step2" {
+			t.Errorf("Task should be done, got %+v", task)
+		}
+	}
+}
+
+func TestChangedColumns(t *testing.T) {
+	task := &m.Task{Name: "test", Status: m.TaskStatusDoing, TodoDate: time.Now()}
+	saved := copyTask(task)
+	if columns := changedColumns(saved, task); len(columns) != 0 {
+		t.Errorf("Expected no changed column, got %v", columns)
+	}
+
+	task.Status = m.TaskStatusDone
+	task.TodoDate = task.TodoDate.UTC()
+	err := task.UserBuffer.Marshal(map[string]int{"counter": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := changedColumns(saved, task)
+	if len(columns) != 2 || columns[0] != m.TaskColumns.Status || columns[1] != m.TaskColumns.UserBuffer {
+		t.Errorf("Expected status and user_buffer to change, got %v", columns)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, NewSQLiteStore(db))
+
+	var archived int
+	err = db.QueryRow(`SELECT count(*) FROM "tasks_archive" WHERE "status"='error'`).Scan(&archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != 1 {
+		t.Errorf("Expected 1 archived task, got %d", archived)
+	}
+}
+
+func TestTablesDefaults(t *testing.T) {
+	tables := Tables{}.withDefaults()
+	if tables.Tasks != "tasks" || tables.Attempts != "task_attempts" ||
+		tables.Migrations != "tasker_schema_migrations" || tables.RateLimits != "task_rate_limits" ||
+		tables.Archive != "tasks_archive" {
+		t.Errorf("Unexpected default tables %+v", tables)
+	}
+
+	tables = Tables{Schema: "app", Tasks: "jobs"}.withDefaults()
+	if tables.Attempts != "jobs_attempts" || tables.Migrations != "jobs_schema_migrations" ||
+		tables.RateLimits != "jobs_rate_limits" || tables.Archive != "jobs_archive" {
+		t.Errorf("Unexpected tables %+v", tables)
+	}
+	if got := tables.qualify(tables.Tasks); got != `"app"."jobs"` {
+		t.Errorf("Unexpected qualified name %s", got)
+	}
+}
+
+func TestSQLiteStoreTables(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQLiteStore(db)
+	s.Tables = Tables{Tasks: "jobs"}
+	err = s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, s)
+
+	// The default tables are left untouched
+	tasks, err := NewSQLiteStore(db).List(ctx, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("Expected no task in the default table, got %d", len(tasks))
+	}
+}
+
+func TestPostgresStoreSchema(t *testing.T) {
+	err := getDBHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dbh.Exec(`DROP SCHEMA IF EXISTS "tasker_test" CASCADE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewPostgresStore(dbh)
+	s.Tables = Tables{Schema: "tasker_test", Tasks: "jobs"}
+	err = s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, s)
+}
+
+func benchmarkEnqueue(b *testing.B, s Store, batch int) {
+	params := make([]EnqueueParams, batch)
+	for i := range params {
+		params[i] = EnqueueParams{Name: "bench", Args: map[string]int{"i": i}}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batch {
+		_, _, err := enqueue(ctx, s, params)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newBenchSQLiteStore(b *testing.B) Store {
+	db, err := sql.Open("sqlite3", filepath.Join(b.TempDir(), "tasker.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return NewSQLiteStore(db)
+}
+
+func newBenchPostgresStore(b *testing.B) Store {
+	err := cleanDB("tasks")
+	if err != nil {
+		b.Fatal("Cannot clean db:" + err.Error())
+	}
+	return NewPostgresStore(dbh)
+}
+
+func BenchmarkSQLiteEnqueue(b *testing.B) {
+	benchmarkEnqueue(b, newBenchSQLiteStore(b), 1)
+}
+
+func BenchmarkSQLiteEnqueueMany(b *testing.B) {
+	benchmarkEnqueue(b, newBenchSQLiteStore(b), 1000)
+}
+
+func BenchmarkPostgresEnqueue(b *testing.B) {
+	benchmarkEnqueue(b, newBenchPostgresStore(b), 1)
+}
+
+func BenchmarkPostgresEnqueueMany(b *testing.B) {
+	benchmarkEnqueue(b, newBenchPostgresStore(b), 1000)
+}
+
+// benchmarkScheduler measures the time spent by the scheduler around a task
+// doing nothing, from the claim to the final update
+func benchmarkScheduler(b *testing.B, s Store, batchSize, workers int) {
+	params := make([]EnqueueParams, b.N)
+	for i := range params {
+		params[i] = EnqueueParams{Name: "bench", TodoDate: time.Now().Add(-time.Second)}
+	}
+	_, _, err := enqueue(ctx, s, params)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	sched := &Scheduler{
+		Tasks: []Task{{
+			Name:  "bench",
+			Steps: []Step{{Name: "noop", Exec: func(t *Task) error { return nil }}},
+		}},
+		Store:          s,
+		ClaimBatchSize: batchSize,
+		Workers:        workers,
+	}
+
+	b.ResetTimer()
+	for done := 0; done < b.N; {
+		n, err := sched.ExecOnce()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n == 0 {
+			b.Fatalf("Only %d of %d tasks were executed", done, b.N)
+		}
+		done += n
+	}
+}
+
+func BenchmarkMemoryScheduler(b *testing.B) {
+	benchmarkScheduler(b, NewMemoryStore(), 100, 8)
+}
+
+func BenchmarkSQLiteScheduler(b *testing.B) {
+	benchmarkScheduler(b, newBenchSQLiteStore(b), 1, 1)
+}
+
+func BenchmarkSQLiteSchedulerBatch(b *testing.B) {
+	benchmarkScheduler(b, newBenchSQLiteStore(b), 100, 8)
+}
+
+func BenchmarkPostgresScheduler(b *testing.B) {
+	benchmarkScheduler(b, newBenchPostgresStore(b), 1, 1)
+}
+
+func BenchmarkPostgresSchedulerBatch(b *testing.B) {
+	benchmarkScheduler(b, newBenchPostgresStore(b), 100, 8)
+}
+
+// benchmarkClaim measures the latency of claiming a single task in a table
+// holding many finished tasks, like a queue running for months
+func benchmarkClaim(b *testing.B, s Store) {
+	seeded := 0
+	for _, history := range []int{10000, 1000000} {
+		seedHistory(b, s, history-seeded)
+		seeded = history
+
+		b.Run(fmt.Sprintf("history=%d", history), func(b *testing.B) {
+			todo := make([]*m.Task, b.N)
+			for i := range todo {
+				todo[i] = &m.Task{Name: "bench", TodoDate: time.Now().Add(-time.Second)}
+			}
+			err := s.Enqueue(ctx, todo...)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				claimed, err := s.Claim(ctx, []string{"bench"}, defaultQueues, time.Now().Add(time.Minute), 1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(claimed) != 1 {
+					b.Fatalf("Expected to claim a task, got %d", len(claimed))
+				}
+			}
+		})
+	}
+}
+
+// seedHistory enqueues n done tasks spread over the last year
+func seedHistory(b *testing.B, s Store, n int) {
+	const batch = 10000
+	now := time.Now()
+	for start := 0; start < n; start += batch {
+		tasks := make([]*m.Task, 0, batch)
+		for i := start; i < n && i < start+batch; i++ {
+			tasks = append(tasks, &m.Task{
+				Name:     "bench",
+				Status:   m.TaskStatusDone,
+				TodoDate: now.Add(-time.Duration(i%(365*24)) * time.Hour),
+			})
+		}
+		err := s.Enqueue(ctx, tasks...)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSQLiteClaim(b *testing.B) {
+	benchmarkClaim(b, newBenchSQLiteStore(b))
+}
+
+func BenchmarkPostgresClaim(b *testing.B) {
+	benchmarkClaim(b, newBenchPostgresStore(b))
+}
+
+func TestSchedulerWithoutInit(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tasker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = MigrateSQLite(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither Init nor InitStore was called
+	previous := ctx
+	ctx = nil
+	t.Cleanup(func() { ctx = previous })
+
+	s := &Scheduler{
+		Store: NewSQLiteStore(db),
+		Tasks: []Task{
+			{Name: "plain", Steps: []Step{{Name: "step1", Exec: testStep}}},
+			{Name: "limited", Steps: []Step{{Name: "step1", Exec: testStep}},
+				RateLimit:   &RateLimit{Tokens: 10, Interval: time.Hour, Distributed: true},
+				Concurrency: &ConcurrencyLimit{Key: "user_address"}},
+		},
+		Retention: []RetentionPolicy{{Status: m.TaskStatusDone, After: time.Hour}},
+	}
+	ids, err := s.EnqueueMany(context.Background(), []EnqueueParams{
+		{Name: "plain", TodoDate: time.Now().Add(-time.Second)},
+		{Name: "limited", Args: map[string]string{"user_address": "a"}, TodoDate: time.Now().Add(-time.Second)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.ExecOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 tasks, got %d", n)
+	}
+	for _, id := range ids {
+		task, err := s.Store.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Status != m.TaskStatusDone {
+			t.Errorf("Expected task %s to be done, got %s", task.Name, task.Status)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tasker
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+const tracerName = "github.com/wesraph/tasker"
+
+// Span attributes set on task and step spans
+const (
+	AttrTaskID   = attribute.Key("tasker.task.id")
+	AttrTaskName = attribute.Key("tasker.task.name")
+	AttrStep     = attribute.Key("tasker.step")
+	AttrRetry    = attribute.Key("tasker.retry")
+
+	AttrEnqueueCount = attribute.Key("tasker.enqueue.count")
+)
+
+var tracerProvider trace.TracerProvider
+
+// traceContextPropagator serializes the span context stored with a task, it
+// doesn't depend on the global propagator so traces are always linked
+var traceContextPropagator = propagation.TraceContext{}
+
+// SetTracerProvider sets the provider used to trace enqueues and task
+// executions, the global OpenTelemetry provider is used by default
+func SetTracerProvider(tp trace.TracerProvider) {
+	tracerProvider = tp
+}
+
+func tracer() trace.Tracer {
+	tp := tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// injectTraceContext stores the span context of ctx in the task
+func injectTraceContext(ctx context.Context, task *m.Task) error {
+	carrier := propagation.MapCarrier{}
+	traceContextPropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return task.TraceContext.Marshal(carrier)
+}
+
+// extractTraceContext returns ctx with the span context stored in the task as
+// remote parent
+func extractTraceContext(ctx context.Context, task *m.Task) context.Context {
+	if !task.TraceContext.Valid {
+		return ctx
+	}
+
+	carrier := propagation.MapCarrier{}
+	err := json.Unmarshal(task.TraceContext.JSON, &carrier)
+	if err != nil {
+		return ctx
+	}
+	return traceContextPropagator.Extract(ctx, carrier)
+}
+
+func taskAttributes(task *m.Task) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		AttrTaskID.String(task.ID),
+		AttrTaskName.String(task.Name),
+		AttrStep.String(task.ActualStep),
+		AttrRetry.Int(task.Retry),
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,101 @@
+package tasker
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	m "github.com/wesraph/tasker/models"
+)
+
+func TestTaskTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	SetTracerProvider(tp)
+	defer SetTracerProvider(nil)
+
+	// Simulate the request which enqueued the task
+	reqCtx, reqSpan := tp.Tracer("test").Start(context.Background(), "POST /tasks")
+	userTask := &m.Task{
+		ID:         "c9f51923-293a-4e3b-a49f-cccd71db4679",
+		Name:       "test",
+		ActualStep: "step1",
+		Status:     m.TaskStatusTodo,
+	}
+	err := injectTraceContext(reqCtx, userTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqSpan.End()
+
+	var stepSpan trace.SpanContext
+	task := &Task{
+		Name:     "test",
+		MaxRetry: 1,
+		UserTask: &UserTask{Task: userTask},
+		Steps: []Step{{
+			Name: "step1",
+			Exec: func(t *Task) error {
+				stepSpan = trace.SpanContextFromContext(t.Context())
+				return nil
+			},
+		}},
+	}
+	err = task.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected request, step and task spans, got %d", len(spans))
+	}
+	req, step, exec := spans[0], spans[1], spans[2]
+
+	if exec.Name != "test" || step.Name != "test.step1" {
+		t.Errorf("Unexpected span names %s and %s", exec.Name, step.Name)
+	}
+	if exec.Parent.SpanID() != req.SpanContext.SpanID() || exec.SpanContext.TraceID() != req.SpanContext.TraceID() {
+		t.Errorf("Task span should be a child of the enqueuing request")
+	}
+	if step.Parent.SpanID() != exec.SpanContext.SpanID() {
+		t.Errorf("Step span should be a child of the task span")
+	}
+	if step.SpanContext.SpanID() != stepSpan.SpanID() {
+		t.Errorf("Task.Context should carry the step span")
+	}
+
+	attrs := map[string]string{}
+	for _, a := range step.Attributes {
+		attrs[string(a.Key)] = a.Value.Emit()
+	}
+	if attrs["tasker.task.id"] != userTask.ID || attrs["tasker.step"] != "step1" || attrs["tasker.retry"] != "0" {
+		t.Errorf("Unexpected step attributes %v", attrs)
+	}
+}
+
+func TestTaskTracingFailure(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer SetTracerProvider(nil)
+
+	task := &Task{
+		Name:     "test",
+		MaxRetry: 1,
+		UserTask: &UserTask{Task: &m.Task{ID: "c9f51923-293a-4e3b-a49f-cccd71db4679", Status: m.TaskStatusTodo}},
+		Steps:    []Step{{Name: "step1", Exec: testFailingStep}},
+	}
+	err := task.Exec()
+	if err != ErrReachedMaxRetry {
+		t.Fatalf("Expected ErrReachedMaxRetry, got %v", err)
+	}
+
+	for _, s := range exporter.GetSpans() {
+		if s.Status.Description == "" {
+			t.Errorf("Span %s should be in error", s.Name)
+		}
+	}
+}